@@ -4,6 +4,8 @@ go 1.16
 
 require (
 	github.com/btcsuite/btcd v0.22.0-beta
+	github.com/btcsuite/btcutil v1.0.3-0.20211129182920-9c4bbabe7acd
 	github.com/stretchr/testify v1.6.1
 	gitlab.com/jaxnet/jaxnetd v0.4.2
+	go.etcd.io/bbolt v1.3.6
 )
@@ -0,0 +1,238 @@
+// Package accounting credits pool workers with the rewards of the blocks found by the pool.
+//
+// Every chain (bitcoin, beacon and each shard) pays its own reward, so the balances are kept per chain.
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/inc4/jax/mining"
+)
+
+type Scheme int
+
+const (
+	// PPLNS (pay per last N shares) splits the reward of the found block between the workers
+	// proportionally to the difficulty of their shares in the last Window difficulty.
+	PPLNS Scheme = iota
+	// PPS (pay per share) credits every share with its expected value, found blocks go to the pool.
+	PPS
+)
+
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// Chain is the chain paying the reward.
+type Chain struct {
+	Btc     bool
+	ShardID uint32 // 0 is beacon
+}
+
+var Beacon = Chain{}
+
+func ChainOf(r *mining.MinerResult) Chain {
+	if r.IsBtc {
+		return Chain{Btc: true}
+	}
+	return Chain{ShardID: r.ShardId}
+}
+
+func (c Chain) String() string {
+	switch {
+	case c.Btc:
+		return "btc"
+	case c.ShardID == 0:
+		return "beacon"
+	default:
+		return fmt.Sprintf("shard %v", c.ShardID)
+	}
+}
+
+type Config struct {
+	Scheme Scheme
+	// Fee is the pool part of every reward, 0.01 is 1%.
+	Fee float64
+	// Window is N of PPLNS in the share difficulty units.
+	Window float64
+	// ShareValue returns the expected reward of the share per chain for PPS,
+	// e.g. block reward * share difficulty / network difficulty.
+	ShareValue func(difficulty float64) map[Chain]int64
+}
+
+func (c *Config) validate() error {
+	if c.Fee < 0 || c.Fee >= 1 {
+		return fmt.Errorf("invalid fee %v", c.Fee)
+	}
+	switch c.Scheme {
+	case PPLNS:
+		if c.Window <= 0 {
+			return fmt.Errorf("PPLNS window must be positive")
+		}
+	case PPS:
+		if c.ShareValue == nil {
+			return fmt.Errorf("PPS needs ShareValue")
+		}
+	default:
+		return fmt.Errorf("unknown scheme %v", c.Scheme)
+	}
+	return nil
+}
+
+type share struct {
+	worker     string
+	difficulty float64
+}
+
+// Ledger keeps the shares and the worker balances. It's safe for concurrent use.
+type Ledger struct {
+	config Config
+
+	mu          sync.Mutex
+	shares      []share // PPLNS window, oldest first
+	windowSum   float64
+	balances    map[Chain]map[string]int64
+	poolBalance map[Chain]int64 // fees, rounding remainders and PPS blocks
+}
+
+func NewLedger(config Config) (*Ledger, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &Ledger{
+		config:      config,
+		balances:    make(map[Chain]map[string]int64),
+		poolBalance: make(map[Chain]int64),
+	}, nil
+}
+
+// OnShare records the accepted share and the blocks it has found, it fits stratum.Config.OnShare.
+func (l *Ledger) OnShare(worker string, difficulty float64, verdict *mining.ShareVerdict) {
+	l.AddShare(worker, difficulty)
+	for _, r := range verdict.Results {
+		l.AddBlock(r)
+	}
+}
+
+// AddShare records the accepted share of the worker with the difficulty it was checked against.
+func (l *Ledger) AddShare(worker string, difficulty float64) {
+	if difficulty <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.Scheme == PPS {
+		for chain, value := range l.config.ShareValue(difficulty) {
+			l.credit(chain, worker, value)
+		}
+		return
+	}
+
+	l.shares = append(l.shares, share{worker: worker, difficulty: difficulty})
+	l.windowSum += difficulty
+	for len(l.shares) > 1 && l.windowSum-l.shares[0].difficulty >= l.config.Window {
+		l.windowSum -= l.shares[0].difficulty
+		l.shares[0] = share{}
+		l.shares = l.shares[1:]
+	}
+}
+
+// AddBlock credits the spendable reward of the found block, burnt and time-locked outputs are not credited.
+// Blocks that failed to submit are ignored, so are pending ones: with the miner submitter call it from SubmitConfig.OnResult.
+func (l *Ledger) AddBlock(r *mining.MinerResult) {
+	if r.Err != nil || r.Pending || r.Spendable <= 0 {
+		return
+	}
+	chain := ChainOf(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.Scheme == PPS || len(l.shares) == 0 {
+		l.poolBalance[chain] += r.Spendable
+		return
+	}
+
+	reward := int64(float64(r.Spendable) * (1 - l.config.Fee))
+	weights := l.windowWeights()
+
+	var paid int64
+	for worker, weight := range weights {
+		credit := int64(float64(reward) * weight)
+		l.credit(chain, worker, credit)
+		paid += credit
+	}
+	l.poolBalance[chain] += r.Spendable - paid
+}
+
+// windowWeights returns the worker parts of the last Window difficulty, the oldest share may count partially.
+func (l *Ledger) windowWeights() map[string]float64 {
+	total := l.windowSum
+	if total > l.config.Window {
+		total = l.config.Window
+	}
+
+	weights := make(map[string]float64)
+	left := total
+	for i := len(l.shares) - 1; i >= 0 && left > 0; i-- {
+		d := l.shares[i].difficulty
+		if d > left {
+			d = left
+		}
+		weights[l.shares[i].worker] += d / total
+		left -= d
+	}
+	return weights
+}
+
+func (l *Ledger) credit(chain Chain, worker string, amount int64) {
+	if amount == 0 {
+		return
+	}
+	if l.balances[chain] == nil {
+		l.balances[chain] = make(map[string]int64)
+	}
+	l.balances[chain][worker] += amount
+}
+
+// Balance returns the worker balance on the chain.
+func (l *Ledger) Balance(worker string, chain Chain) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balances[chain][worker]
+}
+
+// Balances returns non-zero balances of all workers on the chain.
+func (l *Ledger) Balances(chain Chain) map[string]int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	balances := make(map[string]int64, len(l.balances[chain]))
+	for worker, amount := range l.balances[chain] {
+		if amount != 0 {
+			balances[worker] = amount
+		}
+	}
+	return balances
+}
+
+// PoolBalance returns the pool part of the rewards on the chain.
+func (l *Ledger) PoolBalance(chain Chain) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.poolBalance[chain]
+}
+
+// Debit decreases the worker balance, e.g. when it's paid out.
+func (l *Ledger) Debit(worker string, chain Chain, amount int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if amount < 0 || l.balances[chain][worker] < amount {
+		return fmt.Errorf("%w: can't debit %v from %v on %v", ErrInsufficientBalance, amount, worker, chain)
+	}
+	l.balances[chain][worker] -= amount
+	return nil
+}
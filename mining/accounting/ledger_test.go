@@ -0,0 +1,70 @@
+package accounting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/inc4/jax/mining"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPPLNS(t *testing.T) {
+	l, err := NewLedger(Config{Scheme: PPLNS, Fee: 0.1, Window: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.AddShare("old", 5) // out of the window
+	l.AddShare("a", 4)
+	l.AddShare("b", 2)
+	l.AddShare("a", 4)
+
+	l.AddBlock(&mining.MinerResult{ShardId: 0, Amount: 3000, Spendable: 1000})
+	l.AddBlock(&mining.MinerResult{ShardId: 2, Amount: 100, Spendable: 100})
+	l.AddBlock(&mining.MinerResult{ShardId: 4, Amount: 100}) // burnt reward
+	l.AddBlock(&mining.MinerResult{ShardId: 3, Amount: 100, Spendable: 100, Err: errors.New("rejected")})
+	l.AddBlock(&mining.MinerResult{ShardId: 3, Amount: 100, Spendable: 100, Pending: true})
+
+	assert.Equal(t, int64(720), l.Balance("a", Beacon))
+	assert.Equal(t, int64(180), l.Balance("b", Beacon))
+	assert.Equal(t, int64(100), l.PoolBalance(Beacon))
+	assert.Equal(t, int64(72), l.Balance("a", Chain{ShardID: 2}))
+	assert.Equal(t, int64(18), l.Balance("b", Chain{ShardID: 2}))
+	assert.Zero(t, l.Balance("old", Beacon))
+	assert.Empty(t, l.Balances(Chain{ShardID: 3}))
+	assert.Empty(t, l.Balances(Chain{ShardID: 4}))
+
+	// the oldest share counts partially
+	l.AddShare("c", 3)
+	l.AddBlock(&mining.MinerResult{IsBtc: true, Amount: 100, Spendable: 100})
+	assert.Equal(t, map[string]int64{"a": 45, "b": 18, "c": 27}, l.Balances(Chain{Btc: true}))
+
+	assert.NoError(t, l.Debit("a", Beacon, 700))
+	assert.Equal(t, int64(20), l.Balance("a", Beacon))
+	assert.True(t, errors.Is(l.Debit("a", Beacon, 21), ErrInsufficientBalance))
+}
+
+func TestPPS(t *testing.T) {
+	l, err := NewLedger(Config{Scheme: PPS, ShareValue: func(difficulty float64) map[Chain]int64 {
+		return map[Chain]int64{Beacon: int64(difficulty * 10), {ShardID: 1}: int64(difficulty)}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.OnShare("a", 2, &mining.ShareVerdict{Results: []*mining.MinerResult{{ShardId: 1, Amount: 500, Spendable: 500}}})
+	l.AddShare("b", 1)
+
+	assert.Equal(t, map[string]int64{"a": 20, "b": 10}, l.Balances(Beacon))
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1}, l.Balances(Chain{ShardID: 1}))
+	assert.Equal(t, int64(500), l.PoolBalance(Chain{ShardID: 1}))
+}
+
+func TestConfig(t *testing.T) {
+	_, err := NewLedger(Config{Scheme: PPLNS})
+	assert.Error(t, err)
+	_, err = NewLedger(Config{Scheme: PPS})
+	assert.Error(t, err)
+	_, err = NewLedger(Config{Scheme: PPLNS, Window: 1, Fee: 1})
+	assert.Error(t, err)
+}
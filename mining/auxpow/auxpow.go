@@ -0,0 +1,130 @@
+package auxpow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	btcwire "github.com/btcsuite/btcd/wire"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+const maxBranchLength = 32
+
+// AuxPow is the merged mining proof in the namecoin format.
+type AuxPow struct {
+	CoinbaseTx     wire.MsgTx
+	ParentHash     chainhash.Hash
+	CoinbaseBranch []chainhash.Hash
+	CoinbaseIndex  int32
+	ChainBranch    []chainhash.Hash
+	ChainIndex     int32
+	ParentHeader   btcwire.BlockHeader
+}
+
+func DecodeAuxPow(auxPowHex string) (*AuxPow, error) {
+	raw, err := hex.DecodeString(auxPowHex)
+	if err != nil {
+		return nil, err
+	}
+
+	aux := &AuxPow{}
+	if err := aux.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return aux, nil
+}
+
+func (a *AuxPow) Deserialize(r io.Reader) (err error) {
+	if err = a.CoinbaseTx.Deserialize(r); err != nil {
+		return fmt.Errorf("can't decode coinbase tx: %w", err)
+	}
+	if _, err = io.ReadFull(r, a.ParentHash[:]); err != nil {
+		return fmt.Errorf("can't decode parent hash: %w", err)
+	}
+	if a.CoinbaseBranch, a.CoinbaseIndex, err = readBranch(r); err != nil {
+		return fmt.Errorf("can't decode coinbase branch: %w", err)
+	}
+	if a.ChainBranch, a.ChainIndex, err = readBranch(r); err != nil {
+		return fmt.Errorf("can't decode blockchain branch: %w", err)
+	}
+	if err = a.ParentHeader.Deserialize(r); err != nil {
+		return fmt.Errorf("can't decode parent header: %w", err)
+	}
+	return nil
+}
+
+func (a *AuxPow) Serialize(w io.Writer) (err error) {
+	if err = a.CoinbaseTx.Serialize(w); err != nil {
+		return
+	}
+	if _, err = w.Write(a.ParentHash[:]); err != nil {
+		return
+	}
+	if err = writeBranch(w, a.CoinbaseBranch, a.CoinbaseIndex); err != nil {
+		return
+	}
+	if err = writeBranch(w, a.ChainBranch, a.ChainIndex); err != nil {
+		return
+	}
+	return a.ParentHeader.Serialize(w)
+}
+
+// Validate checks that the coinbase is committed by the parent header and contains the commitment.
+func (a *AuxPow) Validate(commitment chainhash.Hash) error {
+	if a.CoinbaseIndex != 0 {
+		return fmt.Errorf("coinbase index must be 0")
+	}
+	if len(a.ChainBranch) != 0 {
+		return fmt.Errorf("jax commitment can't be a part of the aux chains merkle tree")
+	}
+
+	coinbaseHash := a.CoinbaseTx.TxHash()
+	merkleRoot := chainhash.Hash(a.ParentHeader.MerkleRoot)
+	if !chainhash.ValidateCoinbaseMerkleTreeProof(coinbaseHash, a.CoinbaseBranch, merkleRoot) {
+		return fmt.Errorf("coinbase branch doesn't match parent merkle root")
+	}
+
+	if len(a.CoinbaseTx.TxIn) == 0 || !bytes.Contains(a.CoinbaseTx.TxIn[0].SignatureScript, commitment[:]) {
+		return fmt.Errorf("coinbase doesn't contain the commitment %v", commitment)
+	}
+	return nil
+}
+
+func readBranch(r io.Reader) ([]chainhash.Hash, int32, error) {
+	count, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if count > maxBranchLength {
+		return nil, 0, fmt.Errorf("branch is too long: %v", count)
+	}
+
+	branch := make([]chainhash.Hash, count)
+	for i := range branch {
+		if _, err := io.ReadFull(r, branch[i][:]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var index int32
+	if err := binary.Read(r, binary.LittleEndian, &index); err != nil {
+		return nil, 0, err
+	}
+	return branch, index, nil
+}
+
+func writeBranch(w io.Writer, branch []chainhash.Hash, index int32) error {
+	if err := wire.WriteVarInt(w, uint64(len(branch))); err != nil {
+		return err
+	}
+	for i := range branch {
+		if _, err := w.Write(branch[i][:]); err != nil {
+			return err
+		}
+	}
+	return binary.Write(w, binary.LittleEndian, index)
+}
@@ -0,0 +1,49 @@
+package auxpow
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	btcchainhash "github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+func TestAuxPow(t *testing.T) {
+	commitment := chainhash.DoubleHashH([]byte("beacon"))
+
+	aux := &AuxPow{}
+	aux.CoinbaseTx.Version = 1
+	aux.CoinbaseTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex),
+		SignatureScript:  append([]byte{0x03, 0x01, 0x02, 0x03, 0x20}, commitment[:]...),
+	})
+	aux.CoinbaseTx.AddTxOut(&wire.TxOut{Value: 0, PkScript: []byte{0x6a}})
+
+	txHash := chainhash.DoubleHashH([]byte("tx"))
+	aux.CoinbaseBranch = []chainhash.Hash{txHash}
+	root := chainhash.CoinbaseMerkleTreeProofRoot(aux.CoinbaseTx.TxHash(), aux.CoinbaseBranch)
+	aux.ParentHeader.MerkleRoot = btcchainhash.Hash(root)
+	aux.ParentHash = chainhash.Hash(aux.ParentHeader.BlockHash())
+
+	buf := bytes.NewBuffer(nil)
+	if err := aux.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeAuxPow(hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, aux.CoinbaseTx.TxHash(), decoded.CoinbaseTx.TxHash())
+	assert.Equal(t, aux.ParentHeader.BlockHash(), decoded.ParentHeader.BlockHash())
+	assert.Equal(t, aux.CoinbaseBranch, decoded.CoinbaseBranch)
+
+	assert.NoError(t, decoded.Validate(commitment))
+	assert.Error(t, decoded.Validate(txHash))
+
+	decoded.CoinbaseBranch = nil
+	assert.Error(t, decoded.Validate(commitment))
+}
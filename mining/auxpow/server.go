@@ -0,0 +1,218 @@
+package auxpow
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+
+	"github.com/inc4/jax/mining"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+const (
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeMisc           = -1
+)
+
+type Config struct {
+	User, Pass string
+	// ChainID is reported in createauxblock result. Jax commitment is not a part of the aux chains merkle tree,
+	// so it's informational only.
+	ChainID int32
+}
+
+// Server serves namecoin style createauxblock / submitauxblock / getauxblock JSON-RPC methods.
+//
+// The hash returned by createauxblock is the beacon exclusive hash (it commits the merge mining root
+// of all shards) hex encoded in internal byte order, as it is. Parent coinbase must follow the jax BTC coinbase
+// rules and contain these bytes in its scriptSig. The solution is checked against the job snapshot of the hash,
+// so it's accepted while the snapshot is in the job history.
+type Server struct {
+	miner  *mining.Miner
+	config Config
+	log    *log.Logger
+}
+
+type AuxBlock struct {
+	Hash              string `json:"hash"`
+	ChainID           int32  `json:"chainid"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	CoinbaseValue     int64  `json:"coinbasevalue"`
+	Bits              string `json:"bits"`
+	Height            int64  `json:"height"`
+	Target            string `json:"_target"`
+	MergeMiningRoot   string `json:"mergeminingroot"`
+}
+
+type request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *rpcError   `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewServer(miner *mining.Miner, config Config) *Server {
+	return &Server{
+		miner:  miner,
+		config: config,
+		log:    log.Default(),
+	}
+}
+
+func (s *Server) ListenAndServe(address string) error {
+	return http.ListenAndServe(address, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.config.User != "" || s.config.Pass != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.config.User || pass != s.config.Pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &request{}
+	resp := &response{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		resp.Error = &rpcError{Code: errCodeInvalidRequest, Message: err.Error()}
+	} else {
+		resp.ID = req.ID
+		resp.Result, resp.Error = s.handle(req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.log.Println("ERR", err)
+	}
+}
+
+func (s *Server) handle(req *request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "createauxblock":
+		return s.createAuxBlock()
+	case "submitauxblock":
+		return s.submitAuxBlock(req.Params)
+	case "getauxblock":
+		if len(req.Params) == 0 {
+			return s.createAuxBlock()
+		}
+		return s.submitAuxBlock(req.Params)
+	default:
+		return nil, &rpcError{Code: errCodeMethodNotFound, Message: "method not found"}
+	}
+}
+
+// createAuxBlock ignores payout address param: rewards are paid to the address the job was configured with.
+func (s *Server) createAuxBlock() (interface{}, *rpcError) {
+	snapshot := s.miner.Job.CurrentSnapshot()
+	if snapshot == nil {
+		return nil, &rpcError{Code: errCodeMisc, Message: "beacon template is not available yet"}
+	}
+
+	header := snapshot.Beacon.Block.Header.BeaconHeader()
+	hash := header.BeaconExclusiveHash()
+
+	var coinbaseValue int64
+	for _, out := range snapshot.Beacon.Block.Transactions[0].TxOut {
+		coinbaseValue += out.Value
+	}
+
+	// any solved chain is good for the pool, so report the easiest target
+	target := snapshot.Beacon.Target
+	for _, t := range snapshot.ShardsTargets {
+		if t.Target.Cmp(target) > 0 {
+			target = t.Target
+		}
+	}
+
+	return &AuxBlock{
+		Hash:              hex.EncodeToString(hash[:]),
+		ChainID:           s.config.ChainID,
+		PreviousBlockHash: header.PrevBlockHash().String(),
+		CoinbaseValue:     coinbaseValue,
+		Bits:              fmt.Sprintf("%08x", header.Bits()),
+		Height:            snapshot.Beacon.Height,
+		Target:            targetHex(target),
+		MergeMiningRoot:   header.MergeMiningRoot().String(),
+	}, nil
+}
+
+func (s *Server) submitAuxBlock(params []json.RawMessage) (interface{}, *rpcError) {
+	var hashStr, auxPowHex string
+	if len(params) < 2 || json.Unmarshal(params[0], &hashStr) != nil || json.Unmarshal(params[1], &auxPowHex) != nil {
+		return nil, &rpcError{Code: errCodeInvalidParams, Message: "expected params: hash, auxpow"}
+	}
+
+	hash, err := decodeHash(hashStr)
+	if err != nil {
+		return nil, &rpcError{Code: errCodeInvalidParams, Message: err.Error()}
+	}
+	aux, err := DecodeAuxPow(auxPowHex)
+	if err != nil {
+		return nil, &rpcError{Code: errCodeInvalidParams, Message: err.Error()}
+	}
+
+	snapshot, err := s.miner.Job.GetSnapshotByBeaconHash(hash)
+	if err != nil {
+		s.log.Println("stale aux block", err)
+		return false, nil
+	}
+	if err := aux.Validate(hash); err != nil {
+		return nil, &rpcError{Code: errCodeMisc, Message: err.Error()}
+	}
+
+	results := s.miner.CheckSolutionOnSnapshot(snapshot, &aux.ParentHeader, &aux.CoinbaseTx, aux.CoinbaseBranch)
+	accepted := false
+	for _, r := range results {
+		if r.Err != nil {
+			s.log.Println("ERR", r.Err)
+			continue
+		}
+		accepted = true // pending blocks are reported by the miner submitter
+	}
+	return accepted, nil
+}
+
+// decodeHash decodes the hash in internal byte order, the way createauxblock returns it.
+func decodeHash(s string) (hash chainhash.Hash, err error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return
+	}
+	if len(b) != chainhash.HashSize {
+		return hash, fmt.Errorf("hash must be %v bytes, got %v", chainhash.HashSize, len(b))
+	}
+	copy(hash[:], b)
+	return
+}
+
+// targetHex encodes target as a little-endian uint256, the same way namecoin does.
+func targetHex(target *big.Int) string {
+	var buf [chainhash.HashSize]byte
+	b := target.Bytes()
+	for i := range b {
+		buf[i] = b[len(b)-1-i]
+	}
+	return hex.EncodeToString(buf[:])
+}
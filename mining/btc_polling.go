@@ -0,0 +1,119 @@
+package mining
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/btcjson"
+	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
+	"github.com/inc4/jax/mining/job"
+	"log"
+	"sync"
+	"time"
+)
+
+// BtcPoller long-polls bitcoind block templates and combines them with the jax templates
+// into the bitcoin jobs. New job is produced whenever bitcoin or jax template changes.
+type BtcPoller struct {
+	*Miner
+	JobCh chan *job.BitcoinJob // keeps only the latest job, old one is dropped if nobody read it
+
+	mu       sync.Mutex
+	template *btcjson.GetBlockTemplateResult
+	lastJob  *job.BitcoinJob
+	log      *log.Logger
+}
+
+func NewBtcPoller(miner *Miner, btcServerAddress string) *BtcPoller {
+	miner.SetBtcServer(btcServerAddress)
+	return &BtcPoller{
+		Miner: miner,
+		JobCh: make(chan *job.BitcoinJob, 1),
+		log:   log.Default(),
+	}
+}
+
+func (p *BtcPoller) Do() {
+	sub := p.Job.Subscribe()
+	defer p.Job.Unsubscribe(sub)
+
+	go p.watchJaxUpdates(sub)
+	p.fetchBtcTemplate()
+}
+
+func (p *BtcPoller) fetchBtcTemplate() {
+	params := &btcjson.TemplateRequest{
+		Capabilities: []string{"coinbasetxn", "coinbasevalue", "longpoll"},
+		Rules:        []string{"segwit"},
+	}
+	for {
+		rpcClient, err := btcrpcclient.New(p.btc.rpcConf, nil)
+		if err != nil {
+			p.log.Println("ERR:", err)
+			time.Sleep(getTemplateInverval)
+			continue
+		}
+		template, err := rpcClient.GetBlockTemplate(params)
+		rpcClient.Shutdown()
+		if err != nil {
+			p.log.Println("ERR", err)
+			time.Sleep(getTemplateInverval)
+			continue
+		}
+
+		params.LongPollID = template.LongPollID
+		p.log.Println("btc", template.Height)
+
+		if err := p.SetBtcTemplate(template); err != nil {
+			p.log.Println("ERR", err)
+			continue
+		}
+
+		p.mu.Lock()
+		p.template = template
+		p.mu.Unlock()
+
+		if err := p.updateJob(false); err != nil {
+			p.log.Println("ERR", err)
+		}
+	}
+}
+
+func (p *BtcPoller) watchJaxUpdates(sub *job.Subscription) {
+	for ev := range sub.C {
+		// shares of the previous jobs are still checked against their job snapshots,
+		// but beacon tip change makes them useless and removed shard must not be mined
+		clean := false
+		switch e := ev.(type) {
+		case job.BeaconUpdated:
+			clean = e.PrevChanged
+		case job.ShardRemoved:
+			clean = true
+		}
+		if err := p.updateJob(clean); err != nil {
+			p.log.Println("ERR", err)
+		}
+	}
+}
+
+func (p *BtcPoller) updateJob(clean bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.template == nil {
+		return nil
+	}
+
+	j, err := p.Job.NewBitcoinJob(p.template)
+	if err != nil {
+		return fmt.Errorf("can't create bitcoin job: %w", err)
+	}
+
+	j.CleanJobs = clean || p.lastJob == nil || p.lastJob.PrevHash != j.PrevHash
+	p.lastJob = j
+
+	select { // drop stale job
+	case <-p.JobCh:
+	default:
+	}
+	p.JobCh <- j
+	return nil
+}
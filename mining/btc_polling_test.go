@@ -0,0 +1,96 @@
+package mining
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	btcchainhash "github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/inc4/jax/mining/job"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
+)
+
+func testBtcPoller(t *testing.T) *BtcPoller {
+	m := testMiner(t)
+	if err := m.Job.ProcessBeaconTemplate(testBeaconTemplate(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	return &BtcPoller{Miner: m, JobCh: make(chan *job.BitcoinJob, 1), log: log.Default()}
+}
+
+func nextBtcJob(t *testing.T, p *BtcPoller) *job.BitcoinJob {
+	select {
+	case j := <-p.JobCh:
+		return j
+	case <-time.After(time.Second):
+		t.Fatal("no bitcoin job")
+		return nil
+	}
+}
+
+func TestBtcPollerCleanJobs(t *testing.T) {
+	p := testBtcPoller(t)
+	assert.NoError(t, p.updateJob(true)) // no bitcoin template yet
+	assert.Empty(t, p.JobCh)
+
+	value := int64(1000)
+	p.template, _ = testBtcTemplate(btcchainhash.Hash{1}, "207fffff", 2)
+	p.template.CoinbaseValue = &value
+	assert.NoError(t, p.updateJob(false))
+	assert.True(t, nextBtcJob(t, p).CleanJobs) // the first job
+
+	// the same bitcoin tip, the unread job is replaced
+	assert.NoError(t, p.updateJob(false))
+	assert.NoError(t, p.updateJob(false))
+	assert.False(t, nextBtcJob(t, p).CleanJobs)
+	assert.Empty(t, p.JobCh)
+
+	assert.NoError(t, p.updateJob(true))
+	assert.True(t, nextBtcJob(t, p).CleanJobs)
+
+	next, _ := testBtcTemplate(btcchainhash.Hash{2}, "207fffff", 1)
+	next.CoinbaseValue = &value
+	p.template = next
+	assert.NoError(t, p.updateJob(false))
+	assert.True(t, nextBtcJob(t, p).CleanJobs)
+}
+
+func TestBtcPollerJaxUpdates(t *testing.T) {
+	p := testBtcPoller(t)
+	value := int64(1000)
+	p.template, _ = testBtcTemplate(btcchainhash.Hash{1}, "207fffff", 0)
+	p.template.CoinbaseValue = &value
+	assert.NoError(t, p.updateJob(false))
+	nextBtcJob(t, p)
+
+	shard := &jaxjson.GetShardBlockTemplateResult{
+		Bits:              "1d00ffff",
+		Target:            "00000000ffff0000000000000000000000000000000000000000000000000000",
+		ChainWeight:       "0",
+		CoinbaseValue:     &value,
+		Height:            1,
+		PreviousHash:      chainhash.Hash{1}.String(),
+		PrevBlocksMMRRoot: chainhash.Hash{2}.String(),
+	}
+	assert.NoError(t, p.Job.ProcessShardTemplate(shard, 1))
+
+	sub := p.Job.Subscribe()
+	go p.watchJaxUpdates(sub)
+	defer p.Job.Unsubscribe(sub)
+
+	// shard and beacon updates on the same tips
+	assert.NoError(t, p.Job.ProcessShardTemplate(shard, 1))
+	assert.False(t, nextBtcJob(t, p).CleanJobs)
+	assert.NoError(t, p.Job.ProcessBeaconTemplate(testBeaconTemplate(t, 2)))
+	assert.False(t, nextBtcJob(t, p).CleanJobs)
+
+	beacon := testBeaconTemplate(t, 2)
+	beacon.PreviousHash = chainhash.Hash{9}.String()
+	assert.NoError(t, p.Job.ProcessBeaconTemplate(beacon))
+	assert.True(t, nextBtcJob(t, p).CleanJobs)
+
+	assert.NoError(t, p.Job.RemoveShard(1))
+	assert.True(t, nextBtcJob(t, p).CleanJobs)
+}
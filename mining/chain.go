@@ -0,0 +1,42 @@
+package mining
+
+import (
+	"gitlab.com/jaxnet/jaxnetd/network/rpcclient"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+// BlockAt returns the hash of the main chain block at the height and the best height of the shard (0 is beacon).
+func (m *Miner) BlockAt(shardID uint32, height int64) (hash chainhash.Hash, bestHeight int64, err error) {
+	// new client as ForShard changes the client, so it can't be shared
+	rpcClient, err := rpcclient.New(m.rpcConf, nil)
+	if err != nil {
+		return
+	}
+	defer rpcClient.Shutdown()
+
+	count, err := rpcClient.ForShard(shardID).GetBlockCount()
+	if err != nil || height > count {
+		return chainhash.Hash{}, count, err
+	}
+	h, err := rpcClient.ForShard(shardID).GetBlockHash(height)
+	if err != nil {
+		return
+	}
+	return *h, count, nil
+}
+
+// SendTransaction broadcasts the transaction to the shard (0 is beacon).
+func (m *Miner) SendTransaction(shardID uint32, tx *wire.MsgTx) (chainhash.Hash, error) {
+	rpcClient, err := rpcclient.New(m.rpcConf, nil)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	defer rpcClient.Shutdown()
+
+	hash, err := rpcClient.ForShard(shardID).SendRawTransaction(tx)
+	if err != nil {
+		return chainhash.Hash{}, err
+	}
+	return *hash, nil
+}
@@ -0,0 +1,24 @@
+package mining
+
+import "math/big"
+
+// Diff1Target is the target of difficulty 1 share (bdiff).
+var Diff1Target, _ = new(big.Int).SetString("00000000ffff0000000000000000000000000000000000000000000000000000", 16)
+
+// DifficultyToTarget converts share difficulty to the target.
+func DifficultyToTarget(difficulty float64) *big.Int {
+	if difficulty <= 0 {
+		return new(big.Int).Set(Diff1Target)
+	}
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(Diff1Target), big.NewFloat(difficulty)).Int(nil)
+	return target
+}
+
+// TargetToDifficulty converts the target (or hash) to share difficulty.
+func TargetToDifficulty(target *big.Int) float64 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(Diff1Target), new(big.Float).SetInt(target)).Float64()
+	return difficulty
+}
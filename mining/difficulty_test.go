@@ -0,0 +1,13 @@
+package mining
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDifficultyToTarget(t *testing.T) {
+	assert.Equal(t, Diff1Target, DifficultyToTarget(1))
+	assert.Equal(t, float64(1), TargetToDifficulty(Diff1Target))
+	assert.Equal(t, float64(1024), TargetToDifficulty(DifficultyToTarget(1024)))
+}
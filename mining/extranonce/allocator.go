@@ -0,0 +1,128 @@
+// Package extranonce allocates extranonce1 prefixes, so miners working on the same
+// coinbase (job.CoinBaseTx Part1 + extranonce1 + extranonce2 + Part2) never hash the same transaction.
+package extranonce
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrExhausted = errors.New("extranonce space is exhausted")
+
+// Allocator hands out unique extranonce1 values of the fixed size.
+//
+// Values [0, reserved) are kept for the proxy tier: they are given only by AllocateProxy,
+// and a proxy splits the extranonce2 space of its prefix between its own miners.
+// Released values are reused only after the never used ones run out, oldest released first,
+// so late shares of a closed session are unlikely to match a new one.
+type Allocator struct {
+	mu       sync.Mutex
+	size     int
+	reserved uint64
+	pools    [2]pool // regular, proxy
+	inUse    map[uint64]struct{}
+}
+
+type pool struct {
+	next, end uint64   // never used values left: [next, end)
+	free      []uint64 // released values, FIFO
+}
+
+// NewAllocator creates the allocator of extranonce1 of `size` bytes (1..8) with `reserved` values for proxies.
+func NewAllocator(size int, reserved uint64) (*Allocator, error) {
+	if size < 1 || size > 8 {
+		return nil, fmt.Errorf("invalid extranonce1 size %v", size)
+	}
+	total := uint64(1)<<(8*uint(size)) - 1 // the last value is not used, it makes the range fit uint64
+	if reserved > total {
+		return nil, fmt.Errorf("can't reserve %v values of %v", reserved, total)
+	}
+	return &Allocator{
+		size:     size,
+		reserved: reserved,
+		pools: [2]pool{
+			{next: reserved, end: total},
+			{next: 0, end: reserved},
+		},
+		inUse: make(map[uint64]struct{}),
+	}, nil
+}
+
+// Size is the extranonce1 size in bytes.
+func (a *Allocator) Size() int {
+	return a.size
+}
+
+// Allocate returns the unique extranonce1 for the miner session. Call Release when the session is closed.
+func (a *Allocator) Allocate() ([]byte, error) {
+	return a.allocate(&a.pools[0])
+}
+
+// AllocateProxy returns the unique extranonce1 from the reserved range.
+func (a *Allocator) AllocateProxy() ([]byte, error) {
+	return a.allocate(&a.pools[1])
+}
+
+// Release returns the extranonce1 to the allocator. Unknown values are ignored.
+func (a *Allocator) Release(extraNonce1 []byte) {
+	if len(extraNonce1) != a.size {
+		return
+	}
+	n := a.decode(extraNonce1)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.inUse[n]; !ok {
+		return
+	}
+	delete(a.inUse, n)
+
+	p := &a.pools[0]
+	if n < a.reserved {
+		p = &a.pools[1]
+	}
+	p.free = append(p.free, n)
+}
+
+// InUse returns the number of allocated values.
+func (a *Allocator) InUse() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.inUse)
+}
+
+func (a *Allocator) allocate(p *pool) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var n uint64
+	switch {
+	case p.next < p.end:
+		n = p.next
+		p.next++
+	case len(p.free) > 0:
+		n = p.free[0]
+		p.free = p.free[1:]
+	default:
+		return nil, ErrExhausted
+	}
+
+	a.inUse[n] = struct{}{}
+	return a.encode(n), nil
+}
+
+// encode returns n as big endian of the allocator size.
+func (a *Allocator) encode(n uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return buf[8-a.size:]
+}
+
+func (a *Allocator) decode(b []byte) uint64 {
+	buf := make([]byte, 8)
+	copy(buf[8-a.size:], b)
+	return binary.BigEndian.Uint64(buf)
+}
@@ -0,0 +1,64 @@
+package extranonce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocator(t *testing.T) {
+	a, err := NewAllocator(1, 2)
+	assert.NoError(t, err)
+
+	proxy1, err := a.AllocateProxy()
+	assert.NoError(t, err)
+	proxy2, err := a.AllocateProxy()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0}, proxy1)
+	assert.Equal(t, []byte{1}, proxy2)
+	_, err = a.AllocateProxy()
+	assert.Equal(t, ErrExhausted, err)
+
+	seen := make(map[byte]bool)
+	for i := 0; i < 253; i++ {
+		en, err := a.Allocate()
+		assert.NoError(t, err)
+		assert.Len(t, en, 1)
+		assert.False(t, seen[en[0]], "duplicate %x", en)
+		assert.GreaterOrEqual(t, en[0], byte(2), "reserved value given to a miner")
+		seen[en[0]] = true
+	}
+	_, err = a.Allocate()
+	assert.Equal(t, ErrExhausted, err)
+	assert.Equal(t, 255, a.InUse())
+
+	a.Release([]byte{10})
+	a.Release([]byte{20})
+	a.Release([]byte{20}) // double release is ignored
+	en, err := a.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{10}, en)
+	en, err = a.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{20}, en)
+	_, err = a.Allocate()
+	assert.Equal(t, ErrExhausted, err)
+
+	a.Release(proxy2)
+	en, err = a.AllocateProxy()
+	assert.NoError(t, err)
+	assert.Equal(t, proxy2, en)
+}
+
+func TestAllocatorSize(t *testing.T) {
+	a, err := NewAllocator(4, 0)
+	assert.NoError(t, err)
+	en, err := a.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0}, en)
+
+	_, err = NewAllocator(0, 0)
+	assert.Error(t, err)
+	_, err = NewAllocator(1, 256)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package job
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcjson"
+	btcchaincfg "github.com/btcsuite/btcd/chaincfg"
+	btcchainhash "github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"gitlab.com/jaxnet/jaxnetd/node/chaindata"
+	"gitlab.com/jaxnet/jaxnetd/types"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+// BitcoinJob is a stratum-style bitcoin mining job: the bitcoin block template
+// with the coinbase that commits current jax templates.
+type BitcoinJob struct {
+	PrevHash     btcchainhash.Hash
+	Coinbase     *CoinBaseTx
+	MerkleBranch []chainhash.Hash
+	TxHashes     []string // txids of the template transactions (without coinbase) in RPC byte order
+	Version      int32
+	Bits         uint32
+	Time         uint32
+	Height       int64
+	CleanJobs    bool // previous jobs are useless
+}
+
+// NewBitcoinJob builds the bitcoin job from bitcoind getblocktemplate result and current jax templates.
+func (h *Job) NewBitcoinJob(template *btcjson.GetBlockTemplateResult) (*BitcoinJob, error) {
+	if template.CoinbaseValue == nil {
+		return nil, fmt.Errorf("template has no coinbasevalue")
+	}
+
+	prevHash, err := btcchainhash.NewHashFromStr(template.PreviousHash)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode previous block hash: %w", err)
+	}
+	bits, err := strconv.ParseUint(template.Bits, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode bits: %w", err)
+	}
+
+	var fee int64
+	txHashes := make([]string, len(template.Transactions))
+	for i, tx := range template.Transactions {
+		fee += tx.Fee
+		txHashes[i] = tx.TxID
+		if txHashes[i] == "" { // btcd doesn't return txid
+			txHashes[i] = tx.Hash
+		}
+	}
+
+	witnessCommitment, err := hex.DecodeString(template.DefaultWitnessCommitment)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode witness commitment: %w", err)
+	}
+
+	coinbase, err := h.GetBitcoinCoinbase(&CoinBaseData{
+		Reward:            *template.CoinbaseValue - fee,
+		Fee:               fee,
+		Height:            uint32(template.Height),
+		WitnessCommitment: witnessCommitment,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	branch, err := MerkleBranch(txHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BitcoinJob{
+		PrevHash:     *prevHash,
+		Coinbase:     coinbase,
+		MerkleBranch: branch,
+		TxHashes:     txHashes,
+		Version:      template.Version,
+		Bits:         uint32(bits),
+		Time:         uint32(template.CurTime),
+		Height:       template.Height,
+	}, nil
+}
+
+// MerkleBranch returns the coinbase merkle branch for the block with given transactions (without coinbase).
+func MerkleBranch(txHashes []string) ([]chainhash.Hash, error) {
+	hashes := make([]chainhash.Hash, len(txHashes)+1) // first one is a placeholder for coinbase
+	for i, hashHex := range txHashes {
+		hash, err := chainhash.NewHashFromStr(hashHex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tx hash %v: %w", hashHex, err)
+		}
+		hashes[i+1] = *hash
+	}
+	return chainhash.BuildCoinbaseMerkleTreeProof(hashes), nil
+}
+
+// createBitcoinCoinbaseTx is the same as chaindata.CreateBitcoinCoinbaseTx, but pays to any bitcoin address type.
+func (h *Job) createBitcoinCoinbaseTx(data *CoinBaseData, beaconHash []byte) (*wire.MsgTx, error) {
+	if len(data.WitnessCommitment) > 0 && !isWitnessCommitment(data.WitnessCommitment) {
+		return nil, fmt.Errorf("invalid witness commitment %x", data.WitnessCommitment)
+	}
+
+	script, err := chaindata.BTCCoinbaseScript(int64(data.Height), make([]byte, h.Config.ExtraNonce1Size+h.Config.ExtraNonce2Size), beaconHash)
+	if err != nil {
+		return nil, err
+	}
+
+	rewardPkScript := h.Config.btcPkScript
+	if h.Config.BurnBtc {
+		rewardPkScript = types.RawJaxBurnScript
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex),
+		SignatureScript:  script,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	tx.AddTxOut(&wire.TxOut{Value: 0, PkScript: types.RawJaxBurnScript}) // jaxnet link
+	tx.AddTxOut(&wire.TxOut{Value: data.Reward, PkScript: rewardPkScript})
+	tx.AddTxOut(&wire.TxOut{Value: data.Fee, PkScript: h.Config.btcPkScript})
+	if len(data.WitnessCommitment) > 0 {
+		// jax allows the 4th output, and bitcoin takes the commitment with the highest index
+		tx.AddTxOut(&wire.TxOut{Value: 0, PkScript: data.WitnessCommitment})
+	}
+	return tx, nil
+}
+
+// isWitnessCommitment checks the script is OP_RETURN OP_DATA_36 0xaa21a9ed <32 bytes commitment hash> (BIP 141).
+func isWitnessCommitment(script []byte) bool {
+	return len(script) >= 38 && bytes.HasPrefix(script, []byte{txscript.OP_RETURN, txscript.OP_DATA_36, 0xaa, 0x21, 0xa9, 0xed})
+}
+
+// decodeBtcAddress decodes the address and checks it belongs to the bitcoin network.
+func decodeBtcAddress(address string, params *btcchaincfg.Params) (btcutil.Address, error) {
+	addr, err := btcutil.DecodeAddress(address, params)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode btc address: %w", err)
+	}
+	if !addr.IsForNet(params) {
+		return nil, fmt.Errorf("btc address %v is not for %v network", address, params.Name)
+	}
+	return addr, nil
+}
+
+// btcPayToAddrScript supports P2PKH, P2SH, P2WPKH, P2WSH and P2TR addresses.
+func btcPayToAddrScript(addr btcutil.Address) ([]byte, error) {
+	if taproot, ok := addr.(*btcutil.AddressTaproot); ok { // not supported by btcd txscript yet
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(taproot.WitnessProgram()).Script()
+	}
+	return txscript.PayToAddrScript(addr)
+}
@@ -0,0 +1,78 @@
+package job
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+func TestNewBitcoinJob(t *testing.T) {
+	job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, false)
+	job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}}
+	job.pushSnapshot()
+
+	txid1, wtxid1, wtxid2 := chainhash.Hash{1}.String(), chainhash.Hash{2}.String(), chainhash.Hash{3}.String()
+	commitment := "6a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf9"
+	value := int64(625000000)
+	template := &btcjson.GetBlockTemplateResult{
+		Bits:          "1d00ffff",
+		CurTime:       1600000000,
+		Height:        703687,
+		PreviousHash:  chainhash.Hash{4}.String(),
+		Version:       0x20000000,
+		CoinbaseValue: &value,
+		Transactions: []btcjson.GetBlockTemplateResultTx{
+			{TxID: txid1, Hash: wtxid1, Fee: 100},
+			{Hash: wtxid2, Fee: 20}, // btcd doesn't return txid
+		},
+		DefaultWitnessCommitment: commitment,
+	}
+
+	j, err := job.NewBitcoinJob(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{txid1, wtxid2}, j.TxHashes)
+	branch, _ := MerkleBranch(j.TxHashes)
+	assert.Equal(t, branch, j.MerkleBranch)
+	assert.Equal(t, template.PreviousHash, j.PrevHash.String())
+	assert.Equal(t, uint32(0x1d00ffff), j.Bits)
+	assert.Equal(t, uint32(1600000000), j.Time)
+	assert.Equal(t, int64(703687), j.Height)
+	assert.False(t, j.CleanJobs)
+
+	tx := wire.MsgTx{}
+	raw := append(append(append([]byte{}, j.Coinbase.Part1...), make([]byte, 8)...), j.Coinbase.Part2...)
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, tx.TxOut, 4) {
+		assert.Equal(t, value-120, tx.TxOut[1].Value)  // reward
+		assert.Equal(t, int64(120), tx.TxOut[2].Value) // fee
+		assert.Equal(t, commitment, hex.EncodeToString(tx.TxOut[3].PkScript))
+	}
+
+	// without segwit transactions
+	template.DefaultWitnessCommitment = ""
+	j, err = job.NewBitcoinJob(template)
+	if assert.NoError(t, err) {
+		raw := append(append(append([]byte{}, j.Coinbase.Part1...), make([]byte, 8)...), j.Coinbase.Part2...)
+		assert.NoError(t, tx.Deserialize(bytes.NewReader(raw)))
+		assert.Len(t, tx.TxOut, 3)
+	}
+
+	template.DefaultWitnessCommitment = "x"
+	_, err = job.NewBitcoinJob(template)
+	assert.Error(t, err)
+
+	template.DefaultWitnessCommitment = ""
+	template.CoinbaseValue = nil
+	_, err = job.NewBitcoinJob(template)
+	assert.Error(t, err)
+}
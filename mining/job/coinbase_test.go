@@ -1,19 +1,152 @@
 package job
 
 import (
+	"bytes"
 	"encoding/hex"
+	"github.com/inc4/jax/mining/network"
 	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/node/chaindata"
+	"gitlab.com/jaxnet/jaxnetd/txscript"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
 	"testing"
 )
 
 func TestCoinbase(t *testing.T) {
-	job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", true, true)
+	job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, false)
+	job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}}
+	job.pushSnapshot()
 
 	coinbase, err := job.GetBitcoinCoinbase(&CoinBaseData{Reward: 625540727, Fee: 666, Height: 703687})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	assert.Equal(t, "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff3c03c7bc0a08", hex.EncodeToString(coinbase.Part1))
-	assert.Equal(t, "2000000000000000000000000000000000000000000000000000000000000000000d2f503253482f6a61786e65742fffffffff030000000000000000176a152068747470733a2f2f6a61782e6e6574776f726b2077fe4825000000001976a914cd120759aa39d9184d19b8c390d30da979218cea88ac9a020000000000001976a914cd120759aa39d9184d19b8c390d30da979218cea88ac00000000", hex.EncodeToString(coinbase.Part2))
+	assert.Equal(t, "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4b03c7bc0a08", hex.EncodeToString(coinbase.Part1))
+	assert.Equal(t, "066a61786e65742068f7350e8514460b434bd6aa0d27a2fb48c099190cb37304a885fab8f7fe6deb066a61786e65740e2f503253482f6a61786e6574642fffffffff0300000000000000001976a914bc473af4c71c45d5aa3278adc99701ded3740a5488ac77fe4825000000001976a914cd120759aa39d9184d19b8c390d30da979218cea88ac9a020000000000001976a914cd120759aa39d9184d19b8c390d30da979218cea88ac00000000", hex.EncodeToString(coinbase.Part2))
+}
+
+func TestBtcAddress(t *testing.T) {
+	jaxAddress := "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3"
+	for address, pkScript := range map[string]string{
+		"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx":                     "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7": "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262",
+		"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c": "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433",
+		"2N3oefVeg6stiTb5Kh3ozCSkaqmx91FDbsm":                            "a91473d32ac9e4330a071ee1b3a9ccf3997bdd4174d087",
+	} {
+		job, err := NewJob(address, jaxAddress, &network.TestNet, false)
+		if !assert.NoError(t, err, address) {
+			continue
+		}
+		job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}}
+		job.pushSnapshot()
+
+		coinbase, err := job.GetBitcoinCoinbase(&CoinBaseData{Reward: 1, Fee: 2, Height: 3})
+		assert.NoError(t, err)
+		assert.Contains(t, hex.EncodeToString(coinbase.Part2), pkScript, address)
+	}
+
+	_, err := NewJob("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", jaxAddress, &network.TestNet, false)
+	assert.Error(t, err)
+	_, err = NewJob("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", jaxAddress, &network.TestNet, false)
+	assert.Error(t, err)
+}
+
+func TestWitnessCommitment(t *testing.T) {
+	job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, false)
+	job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}}
+	job.pushSnapshot()
+
+	commitment, _ := hex.DecodeString("6a24aa21a9ede2f61c3f71d1defd3fa999dfa36953755c690689799962b48bebd836974e8cf9")
+	coinbase, err := job.GetBitcoinCoinbase(&CoinBaseData{Reward: 1, Fee: 2, Height: 3, WitnessCommitment: commitment})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.MsgTx{}
+	raw := append(append(append([]byte{}, coinbase.Part1...), make([]byte, 8)...), coinbase.Part2...)
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, tx.TxOut, 4)
+	assert.Equal(t, commitment, tx.TxOut[3].PkScript)
+	assert.Equal(t, int64(0), tx.TxOut[3].Value)
+
+	_, err = job.GetBitcoinCoinbase(&CoinBaseData{Reward: 1, Fee: 2, Height: 3, WitnessCommitment: []byte{0x6a, 0x01, 0x00}})
+	assert.Error(t, err)
+}
+
+func TestSplitCoinbase(t *testing.T) {
+	for _, height := range []int64{0, 1, 16, 17, 703687} {
+		script, err := chaindata.BTCCoinbaseScript(height, []byte{1, 2, 3, 4, 5, 6}, make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex),
+			SignatureScript:  script,
+			Sequence:         wire.MaxTxInSequenceNum,
+			Witness:          wire.TxWitness{make([]byte, 32)},
+		})
+		tx.AddTxOut(&wire.TxOut{Value: 1, PkScript: []byte{txscript.OP_TRUE}})
+
+		stripped := bytes.NewBuffer(nil)
+		_ = tx.SerializeNoWitness(stripped)
+		withWitness := bytes.NewBuffer(nil)
+		_ = tx.Serialize(withWitness)
+
+		for _, raw := range [][]byte{stripped.Bytes(), withWitness.Bytes()} {
+			split, err := SplitCoinbase(raw, 2, 4)
+			if !assert.NoError(t, err, height) {
+				continue
+			}
+			assert.Equal(t, len(split.Part1), split.ExtraNonce1Offset)
+			assert.Equal(t, split.ExtraNonce1Offset+2, split.ExtraNonce2Offset)
+			assembled := append(append(append([]byte{}, split.Part1...), 1, 2, 3, 4, 5, 6), split.Part2...)
+			assert.Equal(t, stripped.Bytes(), assembled, height)
+		}
+
+		_, err = SplitCoinbase(stripped.Bytes(), 4, 4)
+		assert.Error(t, err)
+	}
+}
+
+func TestPoolReward(t *testing.T) {
+	for _, burnBtc := range []bool{false, true} {
+		job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, burnBtc)
+
+		beacon, err := job.getCoinbaseTx(0, 50_0000_0000, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shard, err := job.getCoinbaseTx(1, 1000, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}}
+		job.pushSnapshot()
+		coinbase, err := job.GetBitcoinCoinbase(&CoinBaseData{Reward: 625, Fee: 10, Height: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		btc := wire.MsgTx{}
+		raw := append(append(append([]byte{}, coinbase.Part1...), make([]byte, 8)...), coinbase.Part2...)
+		if err := btc.Deserialize(bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+
+		if burnBtc {
+			// the beacon reward is burnt, the lock script still pays to the pool, but it's spendable only after the lock period
+			assert.Zero(t, job.Config.PoolReward(beacon.MsgTx(), false))
+			assert.Equal(t, int64(1000), job.Config.PoolReward(shard.MsgTx(), false))
+			assert.Equal(t, int64(10), job.Config.PoolReward(&btc, true))
+		} else {
+			baseReward := beacon.MsgTx().TxOut[1].Value
+			assert.Less(t, baseReward, int64(50_0000_0000))
+			assert.Equal(t, baseReward, job.Config.PoolReward(beacon.MsgTx(), false))
+			assert.Zero(t, job.Config.PoolReward(shard.MsgTx(), false))
+			assert.Equal(t, int64(635), job.Config.PoolReward(&btc, true))
+		}
+	}
 }
@@ -27,7 +27,13 @@ func (h *Job) decodeBeaconResponse(c *jaxjson.GetBeaconBlockTemplateResult) (tas
 		return nil, err
 	}
 
+	if c.CoinbaseValue == nil {
+		return nil, fmt.Errorf("template has no coinbasevalue")
+	}
 	coinbaseTx, err := h.getCoinbaseTx(0, *c.CoinbaseValue, int32(c.Height))
+	if err != nil {
+		return nil, err
+	}
 	transactions, err := h.unmarshalTransactions(coinbaseTx, c.Transactions)
 	if err != nil {
 		return nil, err
@@ -45,7 +51,6 @@ func (h *Job) decodeBeaconResponse(c *jaxjson.GetBeaconBlockTemplateResult) (tas
 	header.SetK(c.K)
 	header.SetVoteK(c.VoteK)
 	header.SetBTCAux(btcAux)
-	header.SetMergeMiningNumber(h.Config.ShardsCount)
 
 	return &Task{
 		ShardID: 0,
@@ -59,17 +64,16 @@ func (h *Job) decodeBeaconResponse(c *jaxjson.GetBeaconBlockTemplateResult) (tas
 
 }
 
-func (h *Job) decodeShardBlockTemplateResponse(c *jaxjson.GetShardBlockTemplateResult, shardID uint32) (task *Task, err error) {
-	if h.Beacon == nil {
-		return nil, fmt.Errorf("can't initialise SC header")
-	}
+// decodeShardBlockTemplateResponse decodes the shard task merged into beacon, shard reward depends on mmNumber.
+func (h *Job) decodeShardBlockTemplateResponse(c *jaxjson.GetShardBlockTemplateResult, shardID uint32,
+	beacon *Task, beaconAux *wire.CoinbaseAux, mmNumber uint32) (task *Task, err error) {
 
 	actualMMRRoot, prevBlockHash, bits, target, chainWeight, err := h.decodeTemplateValues(c.PrevBlocksMMRRoot, c.PreviousHash, c.Bits, c.Target, c.ChainWeight)
 	if err != nil {
 		return nil, err
 	}
 
-	reward := chaindata.CalcShardBlockSubsidy(h.Config.ShardsCount, bits, h.Beacon.Block.Header.BeaconHeader().K())
+	reward := chaindata.CalcShardBlockSubsidy(mmNumber, bits, beacon.Block.Header.BeaconHeader().K())
 	if h.Config.JaxNetParams.Net != wire.MainNet {
 		reward = *c.CoinbaseValue
 	}
@@ -83,7 +87,7 @@ func (h *Job) decodeShardBlockTemplateResponse(c *jaxjson.GetShardBlockTemplateR
 	}
 
 	header := wire.NewShardBlockHeader(int32(c.Height), *actualMMRRoot, *prevBlockHash, *h.merkleHash(transactions),
-		bits, chainWeight, *h.Beacon.Block.Header.BeaconHeader(), *h.lastBCCoinbaseAux)
+		bits, chainWeight, *beacon.Block.Header.BeaconHeader(), *beaconAux)
 
 	return &Task{
 		ShardID: shardID,
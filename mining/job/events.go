@@ -0,0 +1,110 @@
+package job
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const subscriptionBufferSize = 32
+
+// Event is one of BeaconUpdated, ShardAdded, ShardUpdated, ShardRemoved.
+// Events are published under the job lock after the job snapshot is updated, so CurrentSnapshot
+// already reflects them and they are delivered in the order of the snapshots.
+type Event interface {
+	isEvent()
+}
+
+type BeaconUpdated struct {
+	Height      int64
+	PrevChanged bool // beacon tip changed, blocks of previous snapshots can't be accepted anymore
+}
+
+type ShardAdded struct {
+	ShardID uint32
+}
+
+type ShardUpdated struct {
+	ShardID uint32
+	Height  int64
+}
+
+type ShardRemoved struct {
+	ShardID uint32
+}
+
+func (BeaconUpdated) isEvent() {}
+func (ShardAdded) isEvent()    {}
+func (ShardUpdated) isEvent()  {}
+func (ShardRemoved) isEvent()  {}
+
+// Subscription delivers job events to one consumer.
+//
+// Publishing never blocks: if the consumer is slow and the buffer is full,
+// the oldest pending event is dropped to make room for the new one. Dropped
+// returns how many events were lost; consumers that can't tolerate gaps should
+// resync from CurrentSnapshot when it grows.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	dropped uint64
+}
+
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+type subscribers struct {
+	sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscribe returns a new subscription to the job events. Call Unsubscribe when it's not needed anymore.
+func (h *Job) Subscribe() *Subscription {
+	ch := make(chan Event, subscriptionBufferSize)
+	sub := &Subscription{C: ch, ch: ch}
+
+	h.subscribers.Lock()
+	defer h.subscribers.Unlock()
+
+	if h.subscribers.subs == nil {
+		h.subscribers.subs = make(map[*Subscription]struct{})
+	}
+	h.subscribers.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops delivering events and closes the subscription channel.
+func (h *Job) Unsubscribe(sub *Subscription) {
+	h.subscribers.Lock()
+	defer h.subscribers.Unlock()
+
+	if _, ok := h.subscribers.subs[sub]; ok {
+		delete(h.subscribers.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *Job) publish(ev Event) {
+	h.subscribers.Lock()
+	defer h.subscribers.Unlock()
+
+	for sub := range h.subscribers.subs {
+		sub.send(ev)
+	}
+}
+
+func (s *Subscription) send(ev Event) {
+	for {
+		select {
+		case s.ch <- ev:
+			return
+		default:
+		}
+		select { // buffer is full, drop the oldest event
+		case <-s.ch:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package job
+
+import (
+	"testing"
+
+	btcchaincfg "github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/jaxutil"
+	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+func testEventsJob(t *testing.T) *Job {
+	btcAddress, _ := btcutil.NewAddressPubKeyHash(make([]byte, 20), &btcchaincfg.MainNetParams)
+	jaxAddress, _ := jaxutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.MainNetParams)
+	job, err := NewJob(btcAddress.EncodeAddress(), jaxAddress.EncodeAddress(), &network.MainNet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// pending returns the events already delivered to the subscription.
+func pending(sub *Subscription) (events []Event) {
+	for {
+		select {
+		case ev := <-sub.C:
+			events = append(events, ev)
+		default:
+			return
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	job := testEventsJob(t)
+	sub1 := job.Subscribe()
+	defer job.Unsubscribe(sub1)
+	sub2 := job.Subscribe()
+	defer job.Unsubscribe(sub2)
+
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 5)))
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 5)))
+	next := testBeaconTemplate(t, 5)
+	next.Height++
+	next.PreviousHash = chainhash.Hash{2}.String()
+	assert.NoError(t, job.ProcessBeaconTemplate(next))
+
+	assert.NoError(t, job.ProcessShardTemplate(testShardTemplate(2), 2))
+	assert.NoError(t, job.ProcessShardTemplate(testShardTemplate(2), 2))
+	assert.NoError(t, job.RemoveShard(2))
+	assert.NoError(t, job.RemoveShard(2)) // unknown shard isn't reported
+
+	// failed updates aren't published
+	invalid := testBeaconTemplate(t, 5)
+	invalid.Bits = "x"
+	assert.Error(t, job.ProcessBeaconTemplate(invalid))
+
+	expected := []Event{
+		BeaconUpdated{Height: 10, PrevChanged: true},
+		BeaconUpdated{Height: 10},
+		BeaconUpdated{Height: 11, PrevChanged: true},
+		ShardAdded{ShardID: 2},
+		ShardUpdated{ShardID: 2, Height: 5},
+		ShardUpdated{ShardID: 2, Height: 5},
+		ShardRemoved{ShardID: 2},
+	}
+	assert.Equal(t, expected, pending(sub1))
+	assert.Equal(t, expected, pending(sub2))
+	assert.Zero(t, sub1.Dropped())
+}
+
+func TestSubscriptionDropOldest(t *testing.T) {
+	job := testEventsJob(t)
+	slow := job.Subscribe()
+	defer job.Unsubscribe(slow)
+
+	const dropped = 3
+	for i := 0; i < subscriptionBufferSize+dropped; i++ {
+		job.publish(ShardUpdated{ShardID: 1, Height: int64(i)})
+	}
+
+	events := pending(slow)
+	assert.Len(t, events, subscriptionBufferSize)
+	assert.Equal(t, ShardUpdated{ShardID: 1, Height: dropped}, events[0])
+	assert.Equal(t, ShardUpdated{ShardID: 1, Height: subscriptionBufferSize + dropped - 1}, events[len(events)-1])
+	assert.Equal(t, uint64(dropped), slow.Dropped())
+}
+
+func TestUnsubscribe(t *testing.T) {
+	job := testEventsJob(t)
+	sub := job.Subscribe()
+	other := job.Subscribe()
+	defer job.Unsubscribe(other)
+
+	job.publish(ShardAdded{ShardID: 1})
+	job.Unsubscribe(sub)
+	job.Unsubscribe(sub) // no-op
+	job.publish(ShardAdded{ShardID: 2})
+
+	// pending events are still delivered, then the channel is closed
+	ev, ok := <-sub.C
+	assert.True(t, ok)
+	assert.Equal(t, ShardAdded{ShardID: 1}, ev)
+	_, ok = <-sub.C
+	assert.False(t, ok)
+
+	assert.Equal(t, []Event{ShardAdded{ShardID: 1}, ShardAdded{ShardID: 2}}, pending(other))
+}
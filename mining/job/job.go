@@ -7,28 +7,56 @@
 package job
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/btcsuite/btcutil"
+	"github.com/inc4/jax/mining/network"
 	"gitlab.com/jaxnet/jaxnetd/jaxutil"
-	"gitlab.com/jaxnet/jaxnetd/node/chaindata"
+	"gitlab.com/jaxnet/jaxnetd/txscript"
 	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
 	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
 	"math/big"
 	"sort"
 	"sync"
-
-	mm "gitlab.com/jaxnet/jaxnetd/types/merge_mining_tree"
+	"sync/atomic"
 
 	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
 	"gitlab.com/jaxnet/jaxnetd/types/wire"
 )
 
+const (
+	DefaultExtraNonce1Size = 4
+	DefaultExtraNonce2Size = 4
+)
+
 type Configuration struct {
-	ShardsCount  uint32
+	ShardsCount  uint32 // size of the merge mining tree, comes from the network. Don't set it directly
 	BurnBtc      bool
-	JaxNetParams *chaincfg.Params
+	Network      *network.Network
+	JaxNetParams *chaincfg.Params // Network.JaxParams
 
-	btcMiningAddress jaxutil.Address
+	// space reserved for the stratum extranonces in the bitcoin coinbase script
+	ExtraNonce1Size, ExtraNonce2Size int
+
+	btcMiningAddress btcutil.Address
+	btcPkScript      []byte
 	jaxMiningAddress jaxutil.Address
+	jaxPkScript      []byte
+}
+
+// PoolReward returns the value of the coinbase outputs paid to the pool address, that is what the pool can spend
+// once the coinbase matures. Burnt outputs and the time-locked part of the beacon reward are not counted.
+func (c *Configuration) PoolReward(coinbaseTx *wire.MsgTx, btc bool) (amount int64) {
+	pkScript := c.jaxPkScript
+	if btc {
+		pkScript = c.btcPkScript
+	}
+	for _, out := range coinbaseTx.TxOut {
+		if bytes.Equal(out.PkScript, pkScript) {
+			amount += out.Value
+		}
+	}
+	return
 }
 
 type Task struct {
@@ -40,25 +68,37 @@ type Task struct {
 
 type CoinBaseTx struct {
 	Part1, Part2 []byte
+	JobID        uint64 // ID of the snapshot the coinbase commits to
 }
 type CoinBaseData struct {
 	Reward, Fee int64
 	Height      uint32
+	// WitnessCommitment is the segwit commitment output script (default_witness_commitment of the template).
+	// Optional, it's required only if the block includes segwit transactions.
+	WitnessCommitment []byte
 }
 
+// Job merges jax templates. Template updates are serialized by the job lock and published
+// as immutable snapshots, readers use CurrentSnapshot / GetSnapshot and never block.
+// Tasks are never modified after they are published: updates build new tasks for what changed
+// and share the others, transactions are shared by the tasks built from the same template.
 type Job struct {
-	sync.RWMutex
-
 	Config *Configuration
 
-	Beacon *Task
+	mu sync.Mutex
+
+	beacon         *Task
+	shards         map[uint32]*Task
+	shardTemplates map[uint32]*jaxjson.GetShardBlockTemplateResult // to rebuild tasks when ShardsCount changes
+	shardsTargets  []*Task                                         // it's mergeable `shards` sorted by Target. sort on update
+	slots          *SlotAssignment                                 // of `shards`, updated with shardsTargets
 
-	shards        map[uint32]*Task
-	ShardsTargets []*Task // it's `shards` sorted by Target. sort on update
+	shardsCountFromBeacon bool
 
-	UpdateCh chan bool
+	subscribers subscribers
 
-	lastBCCoinbaseAux *wire.CoinbaseAux
+	history        atomic.Value // *history
+	lastSnapshotID uint64
 }
 
 type JobCompact struct {
@@ -68,18 +108,26 @@ type JobCompact struct {
 	Target    *big.Int
 }
 
-func NewJob(BtcAddress, JaxAddress string, jaxNetParams *chaincfg.Params, burnBtc bool) (job *Job, err error) {
+func NewJob(BtcAddress, JaxAddress string, net *network.Network, burnBtc bool) (job *Job, err error) {
+	jaxNetParams := net.JaxParams
 	job = &Job{
 		Config: &Configuration{
-			ShardsCount:  3,
 			BurnBtc:      burnBtc,
+			Network:      net,
 			JaxNetParams: jaxNetParams,
+
+			ExtraNonce1Size: DefaultExtraNonce1Size,
+			ExtraNonce2Size: DefaultExtraNonce2Size,
 		},
-		shards:   make(map[uint32]*Task),
-		UpdateCh: make(chan bool),
+		shards:         make(map[uint32]*Task),
+		shardTemplates: make(map[uint32]*jaxjson.GetShardBlockTemplateResult),
 	}
 
-	job.Config.btcMiningAddress, err = jaxutil.DecodeAddress(BtcAddress, jaxNetParams)
+	job.Config.btcMiningAddress, err = decodeBtcAddress(BtcAddress, net.BtcParams)
+	if err != nil {
+		return
+	}
+	job.Config.btcPkScript, err = btcPayToAddrScript(job.Config.btcMiningAddress)
 	if err != nil {
 		return
 	}
@@ -88,165 +136,274 @@ func NewJob(BtcAddress, JaxAddress string, jaxNetParams *chaincfg.Params, burnBt
 	if err != nil {
 		return
 	}
+	job.Config.jaxPkScript, err = txscript.PayToAddrScript(job.Config.jaxMiningAddress)
+	if err != nil {
+		return
+	}
 
 	return
 }
 
+// ProcessShardTemplate updates the shard task. Shards without merge mining slots are kept, but not merged
+// until the network has enough shards, they are reported in Snapshot.Unmergeable.
 func (h *Job) ProcessShardTemplate(template *jaxjson.GetShardBlockTemplateResult, shardID uint32) (err error) {
-	h.Lock()
-	defer h.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	h.shards[shardID], err = h.decodeShardBlockTemplateResponse(template, shardID)
+	if h.beacon == nil {
+		return fmt.Errorf("can't decode shard block template response: no beacon template yet")
+	}
+
+	_, known := h.shards[shardID]
+	templates := make(map[uint32]*jaxjson.GetShardBlockTemplateResult, len(h.shardTemplates)+1)
+	for id, t := range h.shardTemplates {
+		templates[id] = t
+	}
+	templates[shardID] = template
+
+	beacon, shards, slots, err := h.buildShards(h.Config.ShardsCount, h.beacon, templates, shardID)
 	if err != nil {
-		return fmt.Errorf("can't decode shard block template response: %w", err)
+		return err
 	}
+	h.shardTemplates = templates
+	h.beacon = beacon
+	h.setShards(shards, slots)
 
-	// clear, populate and sort array by Target
-	h.ShardsTargets = h.ShardsTargets[:0]
-	for _, shardTask := range h.shards {
-		h.ShardsTargets = append(h.ShardsTargets, shardTask)
+	h.pushSnapshot()
+	if !known {
+		h.publish(ShardAdded{ShardID: shardID})
 	}
-	sort.Slice(h.ShardsTargets, func(i, j int) bool { return h.ShardsTargets[i].Target.Cmp(h.ShardsTargets[j].Target) == -1 })
+	h.publish(ShardUpdated{ShardID: shardID, Height: template.Height})
+	return nil
+}
 
-	if err := h.updateMergedMiningProof(); err != nil {
-		return fmt.Errorf("can't update merged mining proof: %w", err)
+// SetShardsCount sets the number of the network shards (e.g. from ListShards) if beacon template doesn't report it.
+func (h *Job) SetShardsCount(count uint32) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shardsCountFromBeacon || h.Config.ShardsCount == count {
+		return nil
+	}
+	if h.beacon == nil {
+		h.Config.ShardsCount = count
+		return nil
 	}
 
-	h.updateBitcoinCoinbase()
+	beacon, shards, slots, err := h.buildShards(count, h.beacon, h.shardTemplates)
+	if err != nil {
+		return err
+	}
+	h.Config.ShardsCount = count
+	h.beacon = beacon
+	h.setShards(shards, slots)
+	h.pushSnapshot()
+	h.publish(BeaconUpdated{Height: h.beacon.Height}) // merge mining commitment changed
 	return nil
 }
 
-func (h *Job) ProcessBeaconTemplate(template *jaxjson.GetBeaconBlockTemplateResult) (err error) {
-	h.Lock()
+// RemoveShard drops the shard task, so it's not merge mined anymore.
+func (h *Job) RemoveShard(shardID uint32) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	h.Beacon, err = h.decodeBeaconResponse(template)
+	if _, ok := h.shards[shardID]; !ok {
+		return nil
+	}
+	templates := make(map[uint32]*jaxjson.GetShardBlockTemplateResult, len(h.shardTemplates))
+	for id, t := range h.shardTemplates {
+		if id != shardID {
+			templates[id] = t
+		}
+	}
+
+	beacon, shards, slots, err := h.buildShards(h.Config.ShardsCount, h.beacon, templates)
 	if err != nil {
-		return fmt.Errorf("can't decode beacon block template response: %w", err)
+		return err
 	}
+	h.shardTemplates = templates
+	h.beacon = beacon
+	h.setShards(shards, slots)
+
+	h.pushSnapshot()
+	h.publish(ShardRemoved{ShardID: shardID})
+	return nil
+}
+
+func (h *Job) ProcessBeaconTemplate(template *jaxjson.GetBeaconBlockTemplateResult) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	h.updateBeaconCoinbaseAux()
-	if err := h.updateMergedMiningProof(); err != nil {
-		return fmt.Errorf("can't update merged mining proof: %w", err)
+	shardsCount := h.Config.ShardsCount
+	if template.Shards != 0 {
+		shardsCount = template.Shards
 	}
 
-	h.Unlock()
+	beacon, err := h.decodeBeaconResponse(template)
+	if err != nil {
+		return fmt.Errorf("can't decode beacon block template response: %w", err)
+	}
+	beacon, shards, slots, err := h.buildShards(shardsCount, beacon, h.shardTemplates)
+	if err != nil {
+		return err
+	}
 
-	h.updateBitcoinCoinbase()
+	// the job is updated only when the whole update is built
+	if template.Shards != 0 {
+		h.shardsCountFromBeacon = true
+	}
+	h.Config.ShardsCount = shardsCount
+	prevBeacon := h.beacon
+	h.beacon = beacon
+	h.setShards(shards, slots)
+
+	h.pushSnapshot()
+	prevChanged := prevBeacon == nil || prevBeacon.Block.Header.PrevBlockHash() != beacon.Block.Header.PrevBlockHash()
+	h.publish(BeaconUpdated{Height: template.Height, PrevChanged: prevChanged})
 	return nil
 }
 
+// GetMinTarget returns the minimal target of the current snapshot or nil if there is no snapshot yet.
 func (h *Job) GetMinTarget() *big.Int {
-	h.RLock()
-	defer h.RUnlock()
+	snapshot := h.CurrentSnapshot()
+	if snapshot == nil {
+		return nil
+	}
 
-	if len(h.ShardsTargets) > 0 {
-		if shard := h.ShardsTargets[0].Target; shard.Cmp(h.Beacon.Target) == -1 {
+	if len(snapshot.ShardsTargets) > 0 {
+		if shard := snapshot.ShardsTargets[0].Target; shard.Cmp(snapshot.Beacon.Target) == -1 {
 			return shard
 		}
 	}
-	if h.Beacon == nil {
-		return nil
-	}
-	return h.Beacon.Target
+	return snapshot.Beacon.Target
 }
 
+// GetJobs returns the beacon and shard jobs of the current snapshot or nil if there is no snapshot yet.
 func (h *Job) GetJobs() []*JobCompact {
-	h.RLock()
-	defer h.RUnlock()
-
-	jobs := make([]*JobCompact, 1, len(h.shards)+1)
-	jobs[0] = &JobCompact{
-		ShardID:   0,
-		Height:    h.Beacon.Height,
-		PrevBlock: h.Beacon.Block.Header.PrevBlockHash(),
-		Target:    h.Beacon.Target,
+	snapshot := h.CurrentSnapshot()
+	if snapshot == nil {
+		return nil
 	}
-	for _, shard := range h.shards { // can't put to array by map key (shardID) - it may be greater that len(h.shards)
+
+	jobs := make([]*JobCompact, 0, len(snapshot.ShardsTargets)+1)
+	for _, t := range append([]*Task{snapshot.Beacon}, snapshot.ShardsTargets...) {
 		jobs = append(jobs, &JobCompact{
-			ShardID:   shard.ShardID,
-			Height:    shard.Height,
-			PrevBlock: shard.Block.Header.PrevBlockHash(),
-			Target:    shard.Target,
+			ShardID:   t.ShardID,
+			Height:    t.Height,
+			PrevBlock: t.Block.Header.PrevBlockHash(),
+			Target:    t.Target,
 		})
 	}
 	return jobs
 }
 
-func (h *Job) GetBitcoinCoinbase(reward, fee int64, height uint32) (*CoinBaseTx, error) {
-	h.Lock()
-	defer h.Unlock()
-
-	if h.Beacon == nil {
-		return nil, fmt.Errorf("job.Beacon is nil")
+// GetBitcoinCoinbase returns the bitcoin coinbase committing the current snapshot.
+func (h *Job) GetBitcoinCoinbase(data *CoinBaseData) (*CoinBaseTx, error) {
+	snapshot := h.CurrentSnapshot()
+	if snapshot == nil {
+		return nil, fmt.Errorf("no beacon template yet")
 	}
 
-	beaconHash := h.Beacon.Block.Header.BeaconHeader().BeaconExclusiveHash()
+	beaconHash := snapshot.Beacon.Block.Header.BeaconHeader().BeaconExclusiveHash()
 
-	coinbaseTx, err := chaindata.CreateBitcoinCoinbaseTx(reward, fee, int32(height), h.Config.btcMiningAddress, beaconHash[:], h.Config.BurnBtc)
+	coinbaseTx, err := h.createBitcoinCoinbaseTx(data, beaconHash[:])
 	if err != nil {
 		return nil, err
 	}
 
-	fakeBlock := wire.MsgBlock{Transactions: []*wire.MsgTx{coinbaseTx.MsgTx()}}
-	part1, part2 := SplitCoinbase(&fakeBlock)
-	return &CoinBaseTx{part1, part2}, nil
+	buf := bytes.NewBuffer(make([]byte, 0, coinbaseTx.SerializeSizeStripped()))
+	if err := coinbaseTx.SerializeNoWitness(buf); err != nil {
+		return nil, err
+	}
+	split, err := SplitCoinbase(buf.Bytes(), h.Config.ExtraNonce1Size, h.Config.ExtraNonce2Size)
+	if err != nil {
+		return nil, err
+	}
+	return &CoinBaseTx{Part1: split.Part1, Part2: split.Part2, JobID: snapshot.ID}, nil
 }
 
-func (h *Job) updateMergedMiningProof() (err error) {
-	tree := mm.NewSparseMerkleTree(h.Config.ShardsCount)
+// buildShards builds the shard tasks merged into beacon, the job isn't modified until the result is set with setShards.
+// Tasks of updated shards are decoded, others are reused unless the merge mining number and so the rewards change.
+// The merge mining proof changes the headers, so the beacon and reused merged tasks are copied with their headers.
+func (h *Job) buildShards(shardsCount uint32, beacon *Task, templates map[uint32]*jaxjson.GetShardBlockTemplateResult,
+	updated ...uint32) (*Task, map[uint32]*Task, *SlotAssignment, error) {
 
-	for id, shard := range h.shards {
-		shardBlockHash := shard.Block.Header.ExclusiveHash()
-		err = tree.SetShardHash(id-1, shardBlockHash) // tree expects slots to be indexed from 0
-		if err != nil {
-			return
+	ids := make([]uint32, 0, len(templates))
+	for id := range templates {
+		ids = append(ids, id)
+	}
+	slots := AssignSlots(shardsCount, ids)
+	mmNumber := uint32(len(slots.Slots))
+	rebuild := h.slots == nil || mmNumber != uint32(len(h.slots.Slots))
+
+	beacon = beacon.withHeaderCopy()
+	var beaconAux *wire.CoinbaseAux
+	shards := make(map[uint32]*Task, len(templates))
+	for id, template := range templates {
+		task, ok := h.shards[id]
+		if !ok || rebuild || containsShard(updated, id) {
+			if beaconAux == nil {
+				beaconAux = beaconCoinbaseAux(beacon)
+			}
+			var err error
+			task, err = h.decodeShardBlockTemplateResponse(template, id, beacon, beaconAux, mmNumber)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("can't decode shard block template response: %w", err)
+			}
+		} else if _, merged := slots.Slots[id]; merged {
+			task = task.withHeaderCopy()
 		}
+		shards[id] = task
 	}
 
-	root, err := tree.Root()
-	if err != nil {
-		return
-	}
-	rootHash, err := chainhash.NewHash(root[:])
+	proof, err := newMergeProof(shardsCount, shards, slots)
 	if err != nil {
-		return
+		return nil, nil, nil, fmt.Errorf("can't update merged mining proof: %w", err)
 	}
-	coding, codingBitLength, err := tree.CatalanNumbersCoding()
-	if err != nil {
-		return
-	}
-	hashes := tree.MarshalOrangeTreeLeafs()
+	proof.apply(beacon, shards, slots)
+	return beacon, shards, slots, nil
+}
 
-	h.Beacon.Block.Header.BeaconHeader().SetMergeMiningRoot(*rootHash)
-	h.Beacon.Block.Header.BeaconHeader().SetMergedMiningTreeCodingProof(hashes, coding, codingBitLength)
+// setShards sets the shards built by buildShards and sorts the mergeable ones by Target to shardsTargets.
+func (h *Job) setShards(shards map[uint32]*Task, slots *SlotAssignment) {
+	h.shards = shards
+	h.slots = slots
 
-	for id, shard := range h.shards {
-		path, err := tree.MerkleProofPath(id - 1) // tree expects slots to be indexed from 0
-		if err != nil {
-			return err
-		}
+	h.shardsTargets = make([]*Task, 0, len(slots.Slots))
+	for id := range slots.Slots {
+		h.shardsTargets = append(h.shardsTargets, shards[id])
+	}
+	sort.Slice(h.shardsTargets, func(i, j int) bool { return h.shardsTargets[i].Target.Cmp(h.shardsTargets[j].Target) == -1 })
+}
 
-		shard.Block.Header.SetShardMerkleProof(path)
-		shard.Block.Header.BeaconHeader().SetMergeMiningRoot(*rootHash)
-		shard.Block.Header.BeaconHeader().SetMergedMiningTreeCodingProof(hashes, coding, codingBitLength)
+func containsShard(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
 	}
+	return false
+}
 
-	return
+// withHeaderCopy returns the task with the copied header and the same transactions, so the header can be modified.
+func (t *Task) withHeaderCopy() *Task {
+	return &Task{
+		ShardID: t.ShardID,
+		Block:   &wire.MsgBlock{Header: t.Block.Header.Copy(), Transactions: t.Block.Transactions},
+		Height:  t.Height,
+		Target:  t.Target,
+	}
 }
 
-func (h *Job) updateBeaconCoinbaseAux() {
-	txs := h.Beacon.Block.Transactions
-	h.lastBCCoinbaseAux = &wire.CoinbaseAux{
+// beaconCoinbaseAux is the beacon coinbase with the hashes of all beacon transactions embedded into shard headers.
+func beaconCoinbaseAux(beacon *Task) *wire.CoinbaseAux {
+	txs := beacon.Block.Transactions
+	aux := &wire.CoinbaseAux{
 		Tx:            *txs[0].Copy(),
 		TxMerkleProof: make([]chainhash.Hash, len(txs)),
 	}
 	for i, tx := range txs {
-		h.lastBCCoinbaseAux.TxMerkleProof[i] = tx.TxHash()
+		aux.TxMerkleProof[i] = tx.TxHash()
 	}
-}
-
-func (h *Job) updateBitcoinCoinbase() {
-	go func() { // avoid deadlocks
-		h.UpdateCh <- true
-	}()
+	return aux
 }
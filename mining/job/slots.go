@@ -0,0 +1,113 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	mm "gitlab.com/jaxnet/jaxnetd/types/merge_mining_tree"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+// ErrUnmergeable is returned for shards without a merge mining slot.
+var ErrUnmergeable = errors.New("shard can't be merge mined")
+
+// MergeMiningSlot returns the merge mining tree slot of the shard.
+// jaxnetd validates the shard proof at position ID-1 of the tree with shardsCount leaves,
+// so shards 1..shardsCount have slots and the others can't be merged.
+func MergeMiningSlot(shardID, shardsCount uint32) (uint32, error) {
+	if shardID == 0 || shardID > shardsCount {
+		return 0, fmt.Errorf("%w: shard %v is out of %v merge mining slots", ErrUnmergeable, shardID, shardsCount)
+	}
+	return shardID - 1, nil
+}
+
+// SlotAssignment maps the active shards onto the merge mining tree slots.
+type SlotAssignment struct {
+	ShardsCount uint32            // size of the merge mining tree
+	Slots       map[uint32]uint32 // shard ID -> slot
+	Unmergeable []uint32          // sorted IDs of the shards without slots
+}
+
+// AssignSlots assigns the slots to shardIDs, IDs may be sparse and unsorted.
+func AssignSlots(shardsCount uint32, shardIDs []uint32) *SlotAssignment {
+	a := &SlotAssignment{ShardsCount: shardsCount, Slots: make(map[uint32]uint32, len(shardIDs))}
+	for _, id := range shardIDs {
+		slot, err := MergeMiningSlot(id, shardsCount)
+		if err != nil {
+			a.Unmergeable = append(a.Unmergeable, id)
+			continue
+		}
+		a.Slots[id] = slot
+	}
+	sort.Slice(a.Unmergeable, func(i, j int) bool { return a.Unmergeable[i] < a.Unmergeable[j] })
+	return a
+}
+
+// Err returns the error wrapping ErrUnmergeable if some shards have no slots.
+func (a *SlotAssignment) Err() error {
+	if len(a.Unmergeable) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: shards %v are out of %v merge mining slots", ErrUnmergeable, a.Unmergeable, a.ShardsCount)
+}
+
+// mergeProof is the merge mining tree of the shards, it's built before any task is modified.
+type mergeProof struct {
+	mmNumber   uint32 // number of merged shards
+	root       chainhash.Hash
+	hashes     []chainhash.Hash
+	coding     []byte
+	codingBits uint32
+	paths      map[uint32][]chainhash.Hash // shard ID -> merkle proof path
+}
+
+func newMergeProof(shardsCount uint32, shards map[uint32]*Task, slots *SlotAssignment) (*mergeProof, error) {
+	proof := &mergeProof{mmNumber: uint32(len(slots.Slots))}
+	if proof.mmNumber == 0 { // network without shards or no mergeable shards, nothing to merge
+		return proof, nil
+	}
+
+	tree := mm.NewSparseMerkleTree(shardsCount)
+	for id, slot := range slots.Slots {
+		if err := tree.SetShardHash(slot, shards[id].Block.Header.ExclusiveHash()); err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := tree.Root()
+	if err != nil {
+		return nil, err
+	}
+	proof.root = root
+	proof.coding, proof.codingBits, err = tree.CatalanNumbersCoding()
+	if err != nil {
+		return nil, err
+	}
+	proof.hashes = tree.MarshalOrangeTreeLeafs()
+
+	proof.paths = make(map[uint32][]chainhash.Hash, len(slots.Slots))
+	for id, slot := range slots.Slots {
+		if proof.paths[id], err = tree.MerkleProofPath(slot); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+// apply sets the merge mining data to the beacon and merged shards headers.
+func (p *mergeProof) apply(beacon *Task, shards map[uint32]*Task, slots *SlotAssignment) {
+	set := func(header *wire.BeaconHeader) {
+		header.SetMergeMiningNumber(p.mmNumber)
+		header.SetMergeMiningRoot(p.root)
+		header.SetMergedMiningTreeCodingProof(p.hashes, p.coding, p.codingBits)
+	}
+
+	set(beacon.Block.Header.BeaconHeader())
+	for id := range slots.Slots {
+		header := shards[id].Block.Header
+		header.SetShardMerkleProof(p.paths[id])
+		set(header.BeaconHeader())
+	}
+}
@@ -0,0 +1,228 @@
+package job
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	btcchaincfg "github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/jaxutil"
+	"gitlab.com/jaxnet/jaxnetd/node/chainctx"
+	"gitlab.com/jaxnet/jaxnetd/node/chaindata"
+	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
+	mm "gitlab.com/jaxnet/jaxnetd/types/merge_mining_tree"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+func TestAssignSlots(t *testing.T) {
+	a := AssignSlots(4, []uint32{9, 3, 0, 1, 4, 5})
+	assert.Equal(t, map[uint32]uint32{1: 0, 3: 2, 4: 3}, a.Slots)
+	assert.Equal(t, []uint32{0, 5, 9}, a.Unmergeable)
+	assert.True(t, errors.Is(a.Err(), ErrUnmergeable))
+
+	a = AssignSlots(4, []uint32{2})
+	assert.NoError(t, a.Err())
+
+	a = AssignSlots(0, []uint32{1})
+	assert.Empty(t, a.Slots)
+	assert.Equal(t, []uint32{1}, a.Unmergeable)
+}
+
+const (
+	testBits   = "1d00ffff"
+	testTarget = "00000000ffff0000000000000000000000000000000000000000000000000000"
+)
+
+func testBeaconTemplate(t *testing.T, shards uint32) *jaxjson.GetBeaconBlockTemplateResult {
+	aux := wire.BTCBlockAux{CoinbaseAux: wire.CoinbaseAux{Tx: *wire.NewMsgTx(wire.TxVersion)}}
+	raw := bytes.NewBuffer(nil)
+	if err := aux.Serialize(raw); err != nil {
+		t.Fatal(err)
+	}
+	value := int64(1000)
+	return &jaxjson.GetBeaconBlockTemplateResult{
+		Bits:              testBits,
+		Target:            testTarget,
+		ChainWeight:       "0",
+		CoinbaseValue:     &value,
+		Height:            10,
+		PreviousHash:      chainhash.Hash{1}.String(),
+		PrevBlocksMMRRoot: chainhash.Hash{2}.String(),
+		Shards:            shards,
+		K:                 0x3c000000,
+		BTCAux:            hex.EncodeToString(raw.Bytes()),
+	}
+}
+
+func testShardTemplate(shardID uint32) *jaxjson.GetShardBlockTemplateResult {
+	value := int64(1000)
+	return &jaxjson.GetShardBlockTemplateResult{
+		Bits:              testBits,
+		Target:            testTarget,
+		ChainWeight:       "0",
+		CoinbaseValue:     &value,
+		Height:            5,
+		PreviousHash:      chainhash.Hash{byte(shardID)}.String(),
+		PrevBlocksMMRRoot: chainhash.Hash{byte(shardID), 1}.String(),
+	}
+}
+
+// validateBeaconMergeMining is chaindata.validateMergeMiningData for the beacon header.
+func validateBeaconMergeMining(header *wire.BeaconHeader) error {
+	mmNumber := header.MergeMiningNumber()
+	root := header.MergeMiningRoot()
+	hashes, coding, codingLen := header.MergedMiningTreeCodingProof()
+	if mmNumber > header.Shards() {
+		return fmt.Errorf("MergeMiningNumber(%v) more than Shards(%v)", mmNumber, header.Shards())
+	}
+	if mmNumber == 0 {
+		if root != chainhash.ZeroHash || len(hashes) != 0 || len(coding) != 0 || codingLen != 0 {
+			return fmt.Errorf("MergeMiningNumber is 0, but MergeMining data not empty")
+		}
+		return nil
+	}
+	if chainhash.NextPowerOfTwo(int(mmNumber)) == int(mmNumber) && mmNumber == header.Shards() {
+		return nil
+	}
+	return mm.NewSparseMerkleTree(header.Shards()).ValidateOrangeTree(codingLen, coding, hashes, mmNumber, root, true)
+}
+
+type testBeaconChain struct {
+	chaindata.BeaconBlockProvider
+	shards uint32
+}
+
+func (c testBeaconChain) BestSnapshot() *chaindata.BestState {
+	return &chaindata.BestState{Shards: c.shards}
+}
+
+// validateMergeMining validates the snapshot blocks as the solved ones are validated by jaxnetd.
+func validateMergeMining(t *testing.T, snapshot *Snapshot) {
+	beaconHeader := snapshot.Beacon.Block.Header.BeaconHeader()
+	assert.NoError(t, validateBeaconMergeMining(beaconHeader))
+	assert.Equal(t, uint32(len(snapshot.ShardsTargets)), beaconHeader.MergeMiningNumber())
+
+	for _, task := range snapshot.ShardsTargets {
+		header := task.Block.Header.(*wire.ShardHeader)
+		header.SetBeaconHeader(beaconHeader, wire.CoinbaseAux{}.FromBlock(snapshot.Beacon.Block, true))
+		ctx := chainctx.ShardChain(task.ShardID, &chaincfg.MainNetParams, snapshot.Beacon.Block, 1)
+		generator := chaindata.NewShardBlockGen(ctx, testBeaconChain{shards: beaconHeader.Shards()})
+		assert.NoError(t, generator.ValidateMergeMiningData(header), task.ShardID)
+
+		var reward int64
+		for _, out := range task.Block.Transactions[0].TxOut {
+			reward += out.Value
+		}
+		k := beaconHeader.K()
+		assert.Equal(t, chaindata.CalcShardBlockSubsidy(beaconHeader.MergeMiningNumber(), header.Bits(), k), reward, task.ShardID)
+	}
+}
+
+func TestMergeMining(t *testing.T) {
+	btcAddress, _ := btcutil.NewAddressPubKeyHash(make([]byte, 20), &btcchaincfg.MainNetParams)
+	jaxAddress, _ := jaxutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.MainNetParams)
+	job, err := NewJob(btcAddress.EncodeAddress(), jaxAddress.EncodeAddress(), &network.MainNet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 5)))
+	validateMergeMining(t, job.CurrentSnapshot())
+
+	for _, id := range []uint32{2, 5, 7} {
+		assert.NoError(t, job.ProcessShardTemplate(testShardTemplate(id), id))
+	}
+	snapshot := job.CurrentSnapshot()
+	assert.Equal(t, []uint32{7}, snapshot.Unmergeable)
+	assert.Len(t, snapshot.ShardsTargets, 2)
+	validateMergeMining(t, snapshot)
+
+	// rewards are rebuilt for the new merge mining number
+	assert.NoError(t, job.RemoveShard(5))
+	assert.Len(t, job.CurrentSnapshot().ShardsTargets, 1)
+	validateMergeMining(t, job.CurrentSnapshot())
+
+	// failed update doesn't change the job
+	id := job.CurrentSnapshot().ID
+	invalid := testBeaconTemplate(t, 8)
+	invalid.Bits = "x"
+	assert.Error(t, job.ProcessBeaconTemplate(invalid))
+	assert.Equal(t, id, job.CurrentSnapshot().ID)
+	assert.Equal(t, uint32(5), job.Config.ShardsCount)
+	assert.Equal(t, []uint32{7}, job.CurrentSnapshot().Unmergeable)
+
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 8)))
+	assert.Len(t, job.CurrentSnapshot().ShardsTargets, 2)
+	assert.Empty(t, job.CurrentSnapshot().Unmergeable)
+	validateMergeMining(t, job.CurrentSnapshot())
+
+	// no mergeable shards, nothing to merge
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 1)))
+	assert.Empty(t, job.CurrentSnapshot().ShardsTargets)
+	assert.Equal(t, []uint32{2, 7}, job.CurrentSnapshot().Unmergeable)
+	validateMergeMining(t, job.CurrentSnapshot())
+
+	// shards count from ListShards
+	job, _ = NewJob(btcAddress.EncodeAddress(), jaxAddress.EncodeAddress(), &network.MainNet, false)
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 0)))
+	assert.NoError(t, job.ProcessShardTemplate(testShardTemplate(1), 1))
+	assert.Empty(t, job.CurrentSnapshot().ShardsTargets)
+
+	sub := job.Subscribe()
+	defer job.Unsubscribe(sub)
+	assert.NoError(t, job.SetShardsCount(1))
+	assert.Len(t, job.CurrentSnapshot().ShardsTargets, 1)
+	assert.Equal(t, BeaconUpdated{Height: 10}, <-sub.C)
+}
+
+func TestSnapshotsCopyOnWrite(t *testing.T) {
+	btcAddress, _ := btcutil.NewAddressPubKeyHash(make([]byte, 20), &btcchaincfg.MainNetParams)
+	jaxAddress, _ := jaxutil.NewAddressPubKeyHash(make([]byte, 20), &chaincfg.MainNetParams)
+	job, err := NewJob(btcAddress.EncodeAddress(), jaxAddress.EncodeAddress(), &network.MainNet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, job.ProcessBeaconTemplate(testBeaconTemplate(t, 5)))
+	for _, id := range []uint32{2, 5} {
+		assert.NoError(t, job.ProcessShardTemplate(testShardTemplate(id), id))
+	}
+
+	prev := job.CurrentSnapshot()
+	headers := map[uint32]wire.BlockHeader{0: prev.Beacon.Block.Header.Copy()}
+	for _, task := range prev.ShardsTargets {
+		headers[task.ShardID] = task.Block.Header.Copy()
+	}
+
+	update := testShardTemplate(2)
+	update.Height++
+	assert.NoError(t, job.ProcessShardTemplate(update, 2))
+	current := job.CurrentSnapshot()
+	assert.NotEqual(t, prev.Beacon.Block.Header.MergeMiningRoot(), current.Beacon.Block.Header.MergeMiningRoot())
+
+	// published tasks aren't modified
+	assert.Equal(t, headers[0], prev.Beacon.Block.Header)
+	for _, task := range prev.ShardsTargets {
+		assert.Equal(t, headers[task.ShardID], task.Block.Header, task.ShardID)
+	}
+
+	// transactions of the unchanged templates are shared
+	assert.True(t, &prev.Beacon.Block.Transactions[0] == &current.Beacon.Block.Transactions[0])
+	shardTxs := func(snapshot *Snapshot, id uint32) *wire.MsgTx {
+		for _, task := range snapshot.ShardsTargets {
+			if task.ShardID == id {
+				return task.Block.Transactions[0]
+			}
+		}
+		return nil
+	}
+	assert.True(t, shardTxs(prev, 5) == shardTxs(current, 5))
+	assert.True(t, shardTxs(prev, 2) != shardTxs(current, 2))
+	validateMergeMining(t, current)
+}
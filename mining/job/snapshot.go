@@ -0,0 +1,98 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+const historySize = 32
+
+var (
+	ErrStaleJob   = errors.New("stale job")
+	ErrUnknownJob = errors.New("unknown job")
+)
+
+// Snapshot is an immutable state of the job made on every template update.
+// Shares must be validated against the snapshot they were computed on.
+// Tasks and their blocks are shared with the job and other snapshots, copy the header to modify a block.
+type Snapshot struct {
+	ID            uint64
+	Beacon        *Task
+	ShardsTargets []*Task  // sorted by Target
+	Unmergeable   []uint32 // shards with templates but without merge mining slots, see AssignSlots
+}
+
+// history is never modified after it's published, every update stores a new one.
+type history struct {
+	snapshots []*Snapshot // oldest first
+}
+
+// CurrentSnapshot returns the latest snapshot or nil if there were no successful template updates yet.
+// It never blocks, template updates publish snapshots with atomic swap.
+func (h *Job) CurrentSnapshot() *Snapshot {
+	hist := h.loadHistory()
+	if len(hist.snapshots) == 0 {
+		return nil
+	}
+	return hist.snapshots[len(hist.snapshots)-1]
+}
+
+// GetSnapshot returns the snapshot by ID or ErrStaleJob / ErrUnknownJob if it's not in the history.
+func (h *Job) GetSnapshot(id uint64) (*Snapshot, error) {
+	hist := h.loadHistory()
+	if len(hist.snapshots) == 0 || id > hist.snapshots[len(hist.snapshots)-1].ID {
+		return nil, fmt.Errorf("%w %v", ErrUnknownJob, id)
+	}
+	oldest := hist.snapshots[0].ID
+	if id < oldest {
+		return nil, fmt.Errorf("%w %v", ErrStaleJob, id)
+	}
+	return hist.snapshots[id-oldest], nil
+}
+
+// GetSnapshotByBeaconHash returns the snapshot by its beacon exclusive hash (the merge mining commitment)
+// or ErrStaleJob if it's not in the history.
+func (h *Job) GetSnapshotByBeaconHash(hash chainhash.Hash) (*Snapshot, error) {
+	hist := h.loadHistory()
+	for i := len(hist.snapshots) - 1; i >= 0; i-- {
+		if hist.snapshots[i].Beacon.Block.Header.BeaconHeader().BeaconExclusiveHash() == hash {
+			return hist.snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: beacon %v", ErrStaleJob, hash)
+}
+
+func (h *Job) loadHistory() *history {
+	hist, _ := h.history.Load().(*history)
+	if hist == nil {
+		return &history{}
+	}
+	return hist
+}
+
+// pushSnapshot must be called under the job lock. Tasks are immutable, so they are shared with the snapshot.
+func (h *Job) pushSnapshot() {
+	if h.beacon == nil {
+		return
+	}
+
+	h.lastSnapshotID++
+	snapshot := &Snapshot{
+		ID:            h.lastSnapshotID,
+		Beacon:        h.beacon,
+		ShardsTargets: h.shardsTargets,
+	}
+	if h.slots != nil {
+		snapshot.Unmergeable = h.slots.Unmergeable
+	}
+
+	prev := h.loadHistory().snapshots
+	if len(prev) == historySize {
+		prev = prev[1:]
+	}
+	snapshots := make([]*Snapshot, len(prev), len(prev)+1)
+	copy(snapshots, prev)
+	h.history.Store(&history{snapshots: append(snapshots, snapshot)})
+}
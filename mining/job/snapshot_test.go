@@ -0,0 +1,44 @@
+package job
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+func TestSnapshots(t *testing.T) {
+	job, _ := NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, false)
+	assert.Nil(t, job.CurrentSnapshot())
+	assert.Nil(t, job.GetJobs())
+	assert.Nil(t, job.GetMinTarget())
+
+	// failed update must not keep the lock
+	for i := 0; i < 2; i++ {
+		assert.Error(t, job.ProcessBeaconTemplate(&jaxjson.GetBeaconBlockTemplateResult{}))
+	}
+
+	job.mu.Lock()
+	job.beacon = &Task{Block: &wire.MsgBlock{Header: wire.EmptyBeaconHeader()}, Target: big.NewInt(10)}
+	for i := 0; i < historySize+2; i++ {
+		job.pushSnapshot()
+	}
+	job.mu.Unlock()
+
+	current := job.CurrentSnapshot()
+	assert.Equal(t, uint64(historySize+2), current.ID)
+	assert.Equal(t, big.NewInt(10), job.GetMinTarget())
+	assert.Len(t, job.GetJobs(), 1)
+
+	snapshot, err := job.GetSnapshot(3)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), snapshot.ID)
+	_, err = job.GetSnapshot(2)
+	assert.True(t, errors.Is(err, ErrStaleJob))
+	_, err = job.GetSnapshot(historySize + 3)
+	assert.True(t, errors.Is(err, ErrUnknownJob))
+}
@@ -2,31 +2,107 @@ package job
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"gitlab.com/jaxnet/jaxnetd/txscript"
 	"gitlab.com/jaxnet/jaxnetd/types/wire"
 )
 
-func SplitCoinbase(block *wire.MsgBlock) ([]byte, []byte) {
-	buf := bytes.NewBuffer(nil)
-	block.Transactions[0].Serialize(buf)
-
-	rawTx := buf.Bytes()
-	heightLenIdx := 42
-	heightLen := int(rawTx[heightLenIdx])
-	if heightLen > 0xF {
-		// if value more than 0xF,
-		// this indicates that height is packed as an opcode OP_0 .. OP_16 (small int)
-		// height = int(rawTx[heightLenIdx] - (txscript.OP_1 - 1))
-		// so height value doesn't gives additional padding
-		heightLen = 0
-	}
-
-	extraNonceLenIdx := heightLenIdx + 1 + heightLen
-	extraNonceLen := int(rawTx[extraNonceLenIdx])
-	// extraNonceLenIdx := 42
-	// extraNonceLen := int(rawTx[extraNonceLenIdx])
-
-	part1 := rawTx[0:extraNonceLenIdx]
-	part1 = append(part1, 0x08)
-	part2 := rawTx[extraNonceLenIdx+extraNonceLen+1:]
-	return part1, part2
+// CoinbaseSplit is the coinbase cut around the extranonce as stratum expects it:
+// coinbase = Part1 + extranonce1 + extranonce2 + Part2.
+type CoinbaseSplit struct {
+	Part1, Part2      []byte
+	ExtraNonce1Offset int // offset of extranonce1 in the coinbase, it's len(Part1)
+	ExtraNonce2Offset int
+}
+
+// SplitCoinbase walks the serialized coinbase transaction and its scriptSig and cuts out the extranonce,
+// which must be the data push right after the BIP34 height with exactly extraNonce1Size+extraNonce2Size bytes.
+// Both plain and witness serializations are accepted, the parts are always built from the plain one,
+// because miners hash the coinbase to get the txid.
+func SplitCoinbase(rawTx []byte, extraNonce1Size, extraNonce2Size int) (*CoinbaseSplit, error) {
+	if extraNonce1Size < 0 || extraNonce2Size < 0 {
+		return nil, fmt.Errorf("invalid extranonce sizes %v, %v", extraNonce1Size, extraNonce2Size)
+	}
+
+	tx := wire.MsgTx{}
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, fmt.Errorf("can't decode coinbase: %w", err)
+	}
+	if len(tx.TxIn) != 1 {
+		return nil, fmt.Errorf("coinbase must have one input, got %v", len(tx.TxIn))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSizeStripped()))
+	if err := tx.SerializeNoWitness(buf); err != nil {
+		return nil, err
+	}
+	rawTx = buf.Bytes()
+
+	// version, input count, previous outpoint, scriptSig length
+	scriptOffset := 4 + wire.VarIntSerializeSize(1) + 32 + 4 +
+		wire.VarIntSerializeSize(uint64(len(tx.TxIn[0].SignatureScript)))
+
+	extraNonceOffset, extraNonceSize, err := coinbaseExtraNonce(tx.TxIn[0].SignatureScript)
+	if err != nil {
+		return nil, err
+	}
+	if extraNonceSize != extraNonce1Size+extraNonce2Size {
+		return nil, fmt.Errorf("coinbase reserves %v bytes for extranonce, need %v",
+			extraNonceSize, extraNonce1Size+extraNonce2Size)
+	}
+
+	offset := scriptOffset + extraNonceOffset
+	return &CoinbaseSplit{
+		Part1:             append([]byte{}, rawTx[:offset]...),
+		Part2:             append([]byte{}, rawTx[offset+extraNonceSize:]...),
+		ExtraNonce1Offset: offset,
+		ExtraNonce2Offset: offset + extraNonce1Size,
+	}, nil
+}
+
+// coinbaseExtraNonce skips the BIP34 height and returns the offset and size of the next data push in the script.
+func coinbaseExtraNonce(script []byte) (offset, size int, err error) {
+	heightOffset, heightSize, err := scriptPush(script)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse coinbase height: %w", err)
+	}
+	heightEnd := heightOffset + heightSize
+	dataOffset, size, err := scriptPush(script[heightEnd:])
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't parse coinbase extranonce: %w", err)
+	}
+	return heightEnd + dataOffset, size, nil
+}
+
+// scriptPush parses the push opcode at the start of the script.
+// It returns the offset of the pushed data and its size, small ints (OP_0, OP_1NEGATE, OP_1 .. OP_16) push nothing.
+func scriptPush(script []byte) (dataOffset, dataSize int, err error) {
+	if len(script) == 0 {
+		return 0, 0, errors.New("unexpected end of script")
+	}
+
+	op := script[0]
+	switch {
+	case op == txscript.OP_0, op == txscript.OP_1NEGATE, op >= txscript.OP_1 && op <= txscript.OP_16:
+		return 1, 0, nil
+	case op <= txscript.OP_DATA_75:
+		dataOffset, dataSize = 1, int(op)
+	case op == txscript.OP_PUSHDATA1 && len(script) >= 2:
+		dataOffset, dataSize = 2, int(script[1])
+	case op == txscript.OP_PUSHDATA2 && len(script) >= 3:
+		dataOffset, dataSize = 3, int(binary.LittleEndian.Uint16(script[1:]))
+	case op == txscript.OP_PUSHDATA4 && len(script) >= 5:
+		dataOffset, dataSize = 5, int(binary.LittleEndian.Uint32(script[1:]))
+	case op > txscript.OP_PUSHDATA4:
+		return 0, 0, fmt.Errorf("0x%x is not a push opcode", op)
+	default:
+		return 0, 0, errors.New("unexpected end of script")
+	}
+
+	if dataSize > len(script)-dataOffset {
+		return 0, 0, fmt.Errorf("push of %v bytes exceeds the script", dataSize)
+	}
+	return dataOffset, dataSize, nil
 }
@@ -2,43 +2,54 @@ package mining
 
 import (
 	"github.com/inc4/jax/mining/job"
+	"github.com/inc4/jax/mining/network"
 	"gitlab.com/jaxnet/jaxnetd/network/rpcclient"
-	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
 	"net/url"
+	"sync"
 )
 
 type Miner struct {
 	Job       *job.Job
 	rpcClient *rpcclient.Client
 	rpcConf   *rpcclient.ConnConfig
+	btc       *btcNode
+
+	submitMu  sync.RWMutex
+	submitter *submitter
 }
 
-func NewMiner(serverAddress, BtcAddress, JaxAddress string, burnBtc bool) (*Miner, error) {
-	rpcConf := jaxRPCConfig(serverAddress)
+// NewMiner creates the miner for the network (see network.ByName), nil means network.TestNet.
+// Found blocks are submitted synchronously, see StartSubmitter.
+func NewMiner(serverAddress, BtcAddress, JaxAddress string, burnBtc bool, net *network.Network) (*Miner, error) {
+	if net == nil {
+		net = &network.TestNet
+	}
+	rpcConf := jaxRPCConfig(serverAddress, net)
 	rpc, err := rpcclient.New(rpcConf, nil)
 	if err != nil {
 		return nil, err
 	}
-	j, err := job.NewJob(BtcAddress, JaxAddress, &chaincfg.TestNet3Params, burnBtc)
+	j, err := job.NewJob(BtcAddress, JaxAddress, net, burnBtc)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Miner{
+	m := &Miner{
 		Job:       j,
 		rpcClient: rpc,
 		rpcConf:   rpcConf,
-	}, nil
+	}
+	return m, nil
 }
 
-func jaxRPCConfig(address string) *rpcclient.ConnConfig {
+func jaxRPCConfig(address string, net *network.Network) *rpcclient.ConnConfig {
 	params, _ := url.Parse(address)
 	user := params.User.Username()
 	pass, _ := params.User.Password()
 	return &rpcclient.ConnConfig{
 		Host:         params.Host,
 		Endpoint:     "ws",
-		Params:       "testnet",
+		Params:       net.JaxRPCParams(),
 		User:         user,
 		Pass:         pass,
 		HTTPPostMode: true,
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"fmt"
+
+	btcchaincfg "github.com/btcsuite/btcd/chaincfg"
+	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
+)
+
+// Network binds jaxnetd chain params with the bitcoin network merge mined with it.
+type Network struct {
+	Name      string
+	JaxParams *chaincfg.Params
+	BtcParams *btcchaincfg.Params
+}
+
+var (
+	MainNet = Network{Name: "mainnet", JaxParams: &chaincfg.MainNetParams, BtcParams: &btcchaincfg.MainNetParams}
+	TestNet = Network{Name: "testnet", JaxParams: &chaincfg.TestNet3Params, BtcParams: &btcchaincfg.TestNet3Params}
+	FastNet = Network{Name: "fastnet", JaxParams: &chaincfg.FastNetParams, BtcParams: &btcchaincfg.TestNet3Params}
+	// RegTest is a local jaxnetd simnet merge mined with bitcoind in regtest mode.
+	RegTest = Network{Name: "regtest", JaxParams: &chaincfg.SimNetParams, BtcParams: &btcchaincfg.RegressionNetParams}
+)
+
+func ByName(name string) (*Network, error) {
+	for _, n := range []*Network{&MainNet, &TestNet, &FastNet, &RegTest} {
+		if n.Name == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network %v", name)
+}
+
+// JaxRPCParams is the network name expected by jaxnetd rpcclient.
+func (n *Network) JaxRPCParams() string {
+	return n.JaxParams.Name
+}
+
+// BtcRPCParams is the network name expected by btcd rpcclient.
+func (n *Network) BtcRPCParams() string {
+	return n.BtcParams.Name
+}
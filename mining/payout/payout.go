@@ -0,0 +1,377 @@
+// Package payout pays the worker balances with batched JAX transactions, separately on the beacon and every shard.
+//
+// The pool spends the coinbase outputs of its matured blocks (see tracker.Confirmed) and the change of its own payouts.
+package payout
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/inc4/jax/mining"
+	"github.com/inc4/jax/mining/accounting"
+	"gitlab.com/jaxnet/jaxnetd/jaxutil"
+	"gitlab.com/jaxnet/jaxnetd/txscript"
+	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+const (
+	// sizes of P2PKH transaction parts used to estimate the fee
+	txOverheadSize = 10
+	txInputSize    = 148
+	txOutputSize   = 34
+)
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Balances is the source of the worker balances, accounting.Ledger implements it.
+type Balances interface {
+	Balances(chain accounting.Chain) map[string]int64
+	Debit(worker string, chain accounting.Chain, amount int64) error
+}
+
+// Sender broadcasts transactions, mining.Miner implements it.
+type Sender interface {
+	SendTransaction(shardID uint32, tx *wire.MsgTx) (chainhash.Hash, error)
+}
+
+var (
+	_ Balances = (*accounting.Ledger)(nil)
+	_ Sender   = (*mining.Miner)(nil)
+)
+
+type Config struct {
+	// Key is the key of the pool address the blocks are mined to (NewMiner JaxAddress).
+	Key    *jaxutil.WIF
+	Params *chaincfg.Params
+	// MinPayout is the threshold, smaller balances wait for the next payout.
+	MinPayout int64
+	// FeePerByte is the network fee paid by the pool.
+	FeePerByte int64
+	// MaxOutputs limits the payments in one transaction.
+	MaxOutputs int
+	// MaxAttempts is how many times the batch is sent before it's marked as failed.
+	MaxAttempts int
+	// WorkerAddress returns the payout address of the worker. By default the worker name
+	// is "address" or "address.rig".
+	WorkerAddress func(worker string) (jaxutil.Address, error)
+}
+
+type BatchState int
+
+const (
+	Pending BatchState = iota // built, not accepted by the node yet
+	Sent
+	Failed // MaxAttempts exceeded, needs manual handling; balances stay debited
+)
+
+// Batch is one payout transaction.
+type Batch struct {
+	ShardID  uint32
+	TxHash   chainhash.Hash // batch ID, the signed transaction is resent as is, so retries are idempotent
+	Tx       *wire.MsgTx
+	Payments map[string]int64 // by worker
+	Fee      int64
+	State    BatchState
+	Attempts int
+	Err      error // last send error
+
+	sending bool // by Pay or Retry, so the batch isn't sent twice at once
+}
+
+type utxo struct {
+	outPoint wire.OutPoint
+	value    int64
+}
+
+// Engine builds, signs and sends the payouts. It's safe for concurrent use.
+type Engine struct {
+	balances Balances
+	sender   Sender
+	config   Config
+	pkScript []byte
+	log      *log.Logger
+
+	mu      sync.Mutex
+	utxos   map[uint32][]utxo // by shard
+	batches []*Batch
+}
+
+func New(balances Balances, sender Sender, config Config) (*Engine, error) {
+	if config.Key == nil || config.Params == nil {
+		return nil, fmt.Errorf("key and params are required")
+	}
+	if config.MaxOutputs <= 0 {
+		config.MaxOutputs = 100
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 10
+	}
+	if config.WorkerAddress == nil {
+		params := config.Params
+		config.WorkerAddress = func(worker string) (jaxutil.Address, error) {
+			return jaxutil.DecodeAddress(strings.SplitN(worker, ".", 2)[0], params)
+		}
+	}
+
+	address, err := jaxutil.NewAddressPubKeyHash(jaxutil.Hash160(config.Key.SerializePubKey()), config.Params)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Engine{
+		balances: balances,
+		sender:   sender,
+		config:   config,
+		pkScript: pkScript,
+		log:      log.Default(),
+		utxos:    make(map[uint32][]utxo),
+	}, nil
+}
+
+// AddBlock adds the coinbase outputs of the matured block to the spendable funds.
+// Outputs paying to other addresses are ignored, so is the time-locked part of the beacon reward:
+// the ledger doesn't credit it either, it credits MinerResult.Spendable.
+func (e *Engine) AddBlock(r *mining.MinerResult) error {
+	if r.IsBtc {
+		return nil
+	}
+
+	block := wire.EmptyShardBlock()
+	if r.ShardId == 0 {
+		block = wire.EmptyBeaconBlock()
+	}
+	if err := block.Deserialize(bytes.NewReader(r.RawBlock)); err != nil {
+		return fmt.Errorf("can't decode block %v: %w", r.BlockHash, err)
+	}
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block %v has no coinbase", r.BlockHash)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.addOutputs(r.ShardId, block.Transactions[0])
+	return nil
+}
+
+func (e *Engine) addOutputs(shardID uint32, tx *wire.MsgTx) {
+	hash := tx.TxHash()
+	for i, out := range tx.TxOut {
+		if out.Value > 0 && bytes.Equal(out.PkScript, e.pkScript) {
+			e.utxos[shardID] = append(e.utxos[shardID], utxo{outPoint: *wire.NewOutPoint(&hash, uint32(i)), value: out.Value})
+		}
+	}
+}
+
+// Funds returns the spendable pool funds on the shard.
+func (e *Engine) Funds(shardID uint32) (funds int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, u := range e.utxos[shardID] {
+		funds += u.value
+	}
+	return
+}
+
+// Pay builds and sends the payouts of the balances above the threshold on the shard (0 is beacon).
+// Balances are debited when the batch is built, sending failures are retried by Retry.
+// Batches are sent without the lock held, so a slow node doesn't block the engine.
+func (e *Engine) Pay(shardID uint32) ([]*Batch, error) {
+	batches, err := e.makeBatches(shardID)
+	e.sendBatches(batches)
+	return batches, err
+}
+
+func (e *Engine) makeBatches(shardID uint32) ([]*Batch, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	chain := accounting.Chain{ShardID: shardID}
+	balances := e.balances.Balances(chain)
+	workers := make([]string, 0, len(balances))
+	for worker, amount := range balances {
+		if amount < e.config.MinPayout || amount <= 0 {
+			continue
+		}
+		if _, err := e.config.WorkerAddress(worker); err != nil { // don't block others
+			e.log.Println("ERR", "invalid address of worker", worker, err)
+			continue
+		}
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers) // deterministic batches
+
+	var batches []*Batch
+	for len(workers) > 0 {
+		n := len(workers)
+		if n > e.config.MaxOutputs {
+			n = e.config.MaxOutputs
+		}
+		payments := make(map[string]int64, n)
+		for _, worker := range workers[:n] {
+			payments[worker] = balances[worker]
+		}
+		workers = workers[n:]
+
+		batch, rest, err := e.buildBatch(shardID, payments)
+		if err != nil {
+			return batches, err
+		}
+		if debited := e.debit(chain, payments); len(debited) < len(payments) {
+			if len(debited) == 0 {
+				continue
+			}
+			// smaller payout, so the funds are enough
+			if batch, rest, err = e.buildBatch(shardID, debited); err != nil {
+				return batches, fmt.Errorf("debited payments %v aren't paid: %w", debited, err)
+			}
+		}
+
+		e.utxos[shardID] = rest
+		e.addOutputs(shardID, batch.Tx) // change
+		batch.sending = true
+		e.batches = append(e.batches, batch)
+		batches = append(batches, batch)
+	}
+	return batches, nil
+}
+
+// debit returns the debited payments, the others are logged and skipped.
+func (e *Engine) debit(chain accounting.Chain, payments map[string]int64) map[string]int64 {
+	debited := make(map[string]int64, len(payments))
+	for worker, amount := range payments {
+		if err := e.balances.Debit(worker, chain, amount); err != nil { // can't happen unless balances are debited by someone else
+			e.log.Println("ERR", err)
+			continue
+		}
+		debited[worker] = amount
+	}
+	return debited
+}
+
+// Retry resends pending batches.
+func (e *Engine) Retry() {
+	e.mu.Lock()
+	var batches []*Batch
+	for _, batch := range e.batches {
+		if batch.State == Pending && !batch.sending {
+			batch.sending = true
+			batches = append(batches, batch)
+		}
+	}
+	e.mu.Unlock()
+
+	e.sendBatches(batches)
+}
+
+// Batches returns copies of all batches made by the engine.
+func (e *Engine) Batches() []*Batch {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	batches := make([]*Batch, len(e.batches))
+	for i, batch := range e.batches {
+		b := *batch
+		batches[i] = &b
+	}
+	return batches
+}
+
+// sendBatches sends the batches marked as sending.
+func (e *Engine) sendBatches(batches []*Batch) {
+	for _, batch := range batches {
+		_, err := e.sender.SendTransaction(batch.ShardID, batch.Tx)
+		if err != nil && strings.Contains(err.Error(), "already have transaction") { // sent before, the response was lost
+			err = nil
+		}
+
+		e.mu.Lock()
+		batch.sending = false
+		batch.Attempts++
+		batch.Err = err
+		switch {
+		case err == nil:
+			batch.State = Sent
+		case batch.Attempts >= e.config.MaxAttempts:
+			batch.State = Failed
+		}
+		e.mu.Unlock()
+	}
+}
+
+// buildBatch must be called under the lock. It doesn't change the funds, rest are the funds left after the batch
+// without its change.
+func (e *Engine) buildBatch(shardID uint32, payments map[string]int64) (batch *Batch, rest []utxo, err error) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+
+	var total int64
+	outputs := make(map[string]int) // address -> output index, workers may share the address
+	for worker, amount := range payments {
+		address, err := e.config.WorkerAddress(worker)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid address of worker %v: %w", worker, err)
+		}
+		if i, ok := outputs[address.EncodeAddress()]; ok {
+			tx.TxOut[i].Value += amount
+		} else {
+			pkScript, err := txscript.PayToAddrScript(address)
+			if err != nil {
+				return nil, nil, err
+			}
+			outputs[address.EncodeAddress()] = len(tx.TxOut)
+			tx.AddTxOut(wire.NewTxOut(amount, pkScript))
+		}
+		total += amount
+	}
+
+	// largest outputs first, fewer inputs - lower fee
+	utxos := append([]utxo(nil), e.utxos[shardID]...)
+	sort.Slice(utxos, func(i, j int) bool { return utxos[i].value > utxos[j].value })
+
+	var in, fee int64
+	used := 0
+	for used < len(utxos) {
+		fee = e.config.FeePerByte * int64(txOverheadSize+txInputSize*used+txOutputSize*(len(tx.TxOut)+1))
+		if in >= total+fee {
+			break
+		}
+		in += utxos[used].value
+		tx.AddTxIn(wire.NewTxIn(&utxos[used].outPoint, nil, nil))
+		used++
+	}
+	fee = e.config.FeePerByte * int64(txOverheadSize+txInputSize*used+txOutputSize*(len(tx.TxOut)+1))
+	if in < total+fee {
+		return nil, nil, fmt.Errorf("%w on shard %v: have %v, need %v", ErrInsufficientFunds, shardID, in, total+fee)
+	}
+	if change := in - total - fee; change > 0 {
+		tx.AddTxOut(wire.NewTxOut(change, e.pkScript))
+	}
+
+	for i := range tx.TxIn {
+		script, err := txscript.SignatureScript(tx, i, e.pkScript, txscript.SigHashAll, e.config.Key.PrivKey, e.config.Key.CompressPubKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't sign payout: %w", err)
+		}
+		tx.TxIn[i].SignatureScript = script
+	}
+
+	return &Batch{
+		ShardID:  shardID,
+		TxHash:   tx.TxHash(),
+		Tx:       tx,
+		Payments: payments,
+		Fee:      fee,
+	}, utxos[used:], nil
+}
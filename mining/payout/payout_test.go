@@ -0,0 +1,227 @@
+package payout
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"github.com/inc4/jax/mining/accounting"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/btcec"
+	"gitlab.com/jaxnet/jaxnetd/jaxutil"
+	"gitlab.com/jaxnet/jaxnetd/node/chaindata"
+	"gitlab.com/jaxnet/jaxnetd/txscript"
+	"gitlab.com/jaxnet/jaxnetd/types/chaincfg"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/wire"
+)
+
+type testSender struct {
+	errs []error
+	sent []*wire.MsgTx
+}
+
+func (s *testSender) SendTransaction(shardID uint32, tx *wire.MsgTx) (chainhash.Hash, error) {
+	s.sent = append(s.sent, tx)
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return chainhash.Hash{}, err
+	}
+	return tx.TxHash(), nil
+}
+
+func TestPayout(t *testing.T) {
+	params := &chaincfg.TestNet3Params
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	wif, _ := jaxutil.NewWIF(key, params, true)
+
+	ledger, _ := accounting.NewLedger(accounting.Config{Scheme: accounting.PPLNS, Window: 10})
+	sender := &testSender{errs: []error{errors.New("timeout"), errors.New("already have transaction")}}
+	engine, err := New(ledger, sender, Config{Key: wif, Params: params, MinPayout: 100, FeePerByte: 1, MaxOutputs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker1 := newAddress(t, params)
+	worker2 := newAddress(t, params)
+	if worker2 < worker1+".rig1" { // batches are sorted by worker
+		worker1, worker2 = worker2, worker1
+	}
+	ledger.AddShare(worker1+".rig1", 6)
+	ledger.AddShare(worker2, 3)
+	ledger.AddShare("small", 1)
+	ledger.AddBlock(&mining.MinerResult{ShardId: 1, Amount: 1000, Spendable: 1000})
+
+	addBlock(t, engine, 1, 5000)
+	assert.Equal(t, int64(5000), engine.Funds(1))
+
+	batches, err := engine.Pay(1)
+	assert.NoError(t, err)
+	if !assert.Len(t, batches, 2) {
+		return
+	}
+	assert.Equal(t, map[string]int64{worker1 + ".rig1": 600}, batches[0].Payments)
+	assert.Equal(t, map[string]int64{worker2: 300}, batches[1].Payments)
+	assert.Equal(t, Pending, batches[0].State) // timeout
+	assert.Equal(t, Sent, batches[1].State)    // already sent
+	assert.Zero(t, ledger.Balance(worker1+".rig1", accounting.Chain{ShardID: 1}))
+	assert.Equal(t, int64(100), ledger.Balance("small", accounting.Chain{ShardID: 1}))
+
+	// the second batch spends the change of the first one
+	assert.Equal(t, batches[0].TxHash, batches[1].Tx.TxIn[0].PreviousOutPoint.Hash)
+	assert.Equal(t, int64(5000-600-300)-batches[0].Fee-batches[1].Fee, engine.Funds(1))
+
+	engine.Retry()
+	assert.Equal(t, Sent, batches[0].State)
+	assert.Equal(t, 2, batches[0].Attempts)
+	assert.Equal(t, batches[0].TxHash, sender.sent[2].TxHash()) // the same transaction
+
+	// nothing to pay anymore
+	batches, err = engine.Pay(1)
+	assert.NoError(t, err)
+	assert.Empty(t, batches)
+
+	ledger.AddBlock(&mining.MinerResult{ShardId: 2, Amount: 1000, Spendable: 1000})
+	_, err = engine.Pay(2)
+	assert.True(t, errors.Is(err, ErrInsufficientFunds))
+	assert.Equal(t, int64(600), ledger.Balance(worker1+".rig1", accounting.Chain{ShardID: 2}))
+}
+
+func TestPayoutDebitFailure(t *testing.T) {
+	params := &chaincfg.TestNet3Params
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	wif, _ := jaxutil.NewWIF(key, params, true)
+
+	ledger, _ := accounting.NewLedger(accounting.Config{Scheme: accounting.PPLNS, Window: 10})
+	worker1, worker2 := newAddress(t, params), newAddress(t, params)
+	ledger.AddShare(worker1, 1)
+	ledger.AddShare(worker2, 1)
+	ledger.AddBlock(&mining.MinerResult{ShardId: 1, Amount: 1000, Spendable: 1000})
+
+	balances := &failingBalances{Ledger: ledger, worker: worker2}
+	sender := &blockingSender{sending: make(chan struct{}), release: make(chan struct{})}
+	engine, err := New(balances, sender, Config{Key: wif, Params: params, FeePerByte: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addBlock(t, engine, 1, 5000)
+
+	paid := make(chan []*Batch)
+	go func() {
+		batches, err := engine.Pay(1)
+		assert.NoError(t, err)
+		paid <- batches
+	}()
+	<-sender.sending
+	// the engine isn't locked while the batch is sent
+	batches := engine.Batches()
+	if assert.Len(t, batches, 1) {
+		assert.Equal(t, map[string]int64{worker1: 500}, batches[0].Payments)
+		assert.Equal(t, int64(5000-500)-batches[0].Fee, engine.Funds(1))
+	}
+	engine.Retry() // the batch is being sent
+	close(sender.release)
+
+	batches = <-paid
+	assert.Equal(t, Sent, batches[0].State)
+	assert.Equal(t, 1, batches[0].Attempts)
+	assert.Equal(t, int64(500), ledger.Balance(worker2, accounting.Chain{ShardID: 1}))
+}
+
+// failingBalances fails to debit the worker as if it was debited by someone else.
+type failingBalances struct {
+	*accounting.Ledger
+	worker string
+}
+
+func (b *failingBalances) Debit(worker string, chain accounting.Chain, amount int64) error {
+	if worker == b.worker {
+		return accounting.ErrInsufficientBalance
+	}
+	return b.Ledger.Debit(worker, chain, amount)
+}
+
+type blockingSender struct {
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSender) SendTransaction(shardID uint32, tx *wire.MsgTx) (chainhash.Hash, error) {
+	s.sending <- struct{}{}
+	<-s.release
+	return tx.TxHash(), nil
+}
+
+// addBlock adds the matured pool block paying value to the engine.
+func addBlock(t *testing.T, engine *Engine, shardID uint32, value int64) {
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{1}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_RETURN}))
+	coinbase.AddTxOut(wire.NewTxOut(value, engine.pkScript))
+	beacon := wire.NewBeaconBlockHeader(1, 1, chainhash.Hash{}, chainhash.Hash{}, chainhash.Hash{}, chainhash.Hash{}, time.Unix(1, 0), 0, big.NewInt(0), 0)
+	block := wire.MsgBlock{
+		Header:       wire.NewShardBlockHeader(1, chainhash.Hash{}, chainhash.Hash{}, chainhash.Hash{}, 0, big.NewInt(0), *beacon, wire.CoinbaseAux{Tx: *coinbase}),
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+	raw := bytes.NewBuffer(nil)
+	if err := block.Serialize(raw); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, engine.AddBlock(&mining.MinerResult{ShardId: shardID, RawBlock: raw.Bytes()}))
+}
+
+func newAddress(t *testing.T, params *chaincfg.Params) string {
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	address, err := jaxutil.NewAddressPubKeyHash(jaxutil.Hash160(key.PubKey().SerializeCompressed()), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address.EncodeAddress()
+}
+
+func TestPayoutBeacon(t *testing.T) {
+	params := &chaincfg.TestNet3Params
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	wif, _ := jaxutil.NewWIF(key, params, true)
+
+	ledger, _ := accounting.NewLedger(accounting.Config{Scheme: accounting.PPLNS, Fee: 0.1, Window: 10})
+	engine, err := New(ledger, &testSender{}, Config{Key: wif, Params: params, FeePerByte: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the beacon reward is partially locked by HTLC
+	address, _ := jaxutil.NewAddressPubKeyHash(jaxutil.Hash160(wif.SerializePubKey()), params)
+	coinbase, err := chaindata.CreateJaxCoinbaseTx(50_0000_0000, 0, 1, 0, address, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spendable := coinbase.MsgTx().TxOut[1].Value
+	block := wire.MsgBlock{
+		Header:       wire.NewBeaconBlockHeader(1, 1, chainhash.Hash{}, chainhash.Hash{}, chainhash.Hash{}, chainhash.Hash{}, time.Unix(1, 0), 0, big.NewInt(0), 0),
+		Transactions: []*wire.MsgTx{coinbase.MsgTx()},
+	}
+	raw := bytes.NewBuffer(nil)
+	if err := block.Serialize(raw); err != nil {
+		t.Fatal(err)
+	}
+	result := &mining.MinerResult{ShardId: 0, Amount: 50_0000_0000, Spendable: spendable, RawBlock: raw.Bytes()}
+
+	worker := newAddress(t, params)
+	ledger.AddShare(worker, 1)
+	ledger.AddBlock(result)
+	assert.NoError(t, engine.AddBlock(result))
+	assert.Equal(t, spendable, engine.Funds(0))
+	assert.Equal(t, spendable/10*9, ledger.Balance(worker, accounting.Beacon))
+
+	batches, err := engine.Pay(0)
+	assert.NoError(t, err)
+	if assert.Len(t, batches, 1) {
+		assert.Equal(t, Sent, batches[0].State)
+		assert.Equal(t, map[string]int64{worker: spendable / 10 * 9}, batches[0].Payments)
+	}
+}
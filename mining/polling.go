@@ -5,6 +5,7 @@ import (
 	"gitlab.com/jaxnet/jaxnetd/network/rpcclient"
 	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -13,12 +14,15 @@ const (
 )
 
 type Poller struct {
-	Miner
+	*Miner
 	shards map[uint32]context.CancelFunc
-	log    *log.Logger
+	// shardsMu serializes shard template updates with shard removal,
+	// so the template fetched before the removal can't add the shard back
+	shardsMu sync.Mutex
+	log      *log.Logger
 }
 
-func NewPoller(miner Miner) *Poller {
+func NewPoller(miner *Miner) *Poller {
 	return &Poller{
 		Miner:  miner,
 		shards: make(map[uint32]context.CancelFunc),
@@ -40,6 +44,17 @@ func (p *Poller) fetchShards() {
 		p.log.Println("ERR", err)
 		return
 	}
+	// used only if beacon template doesn't report the number of shards
+	var shardsCount uint32
+	for id := range res.Shards {
+		if id > shardsCount {
+			shardsCount = id
+		}
+	}
+	if err := p.Job.SetShardsCount(shardsCount); err != nil {
+		p.log.Println("ERR", err)
+	}
+
 	for id, shard := range res.Shards {
 		if !shard.Enabled {
 			continue
@@ -50,10 +65,13 @@ func (p *Poller) fetchShards() {
 			go p.fetchShardTemplate(ctx, id)
 		}
 	}
-	for id, _ := range p.shards {
-		if _, ok := res.Shards[id]; !ok {
-			// TODO shard deleted
+	for id, cancel := range p.shards {
+		if shard, ok := res.Shards[id]; ok && shard.Enabled {
+			continue
 		}
+		p.log.Println("shard removed", id)
+		delete(p.shards, id)
+		p.removeShard(id, cancel)
 	}
 }
 
@@ -67,9 +85,11 @@ func (p *Poller) fetchBeaconTemplate() {
 		rpcClient, err := rpcclient.New(p.rpcConf, nil)
 		if err != nil {
 			p.log.Println("ERR:", err)
+			time.Sleep(getTemplateInverval)
 			continue
 		}
 		template, err := rpcClient.GetBeaconBlockTemplate(params)
+		rpcClient.Shutdown()
 		if err == nil {
 			params.LongPollID = template.LongPollID
 			p.log.Println("beacon", template.Height)
@@ -93,42 +113,74 @@ func (p *Poller) fetchShardTemplate(ctx context.Context, id uint32) {
 		},
 	}
 	for {
-		rpcClient, err := rpcclient.New(p.rpcConf, nil)
-		if err != nil {
-			p.log.Println("ERR:", err)
-			continue
-		}
-		ch := GetShardBlockTemplateAsync(rpcClient.ForShard(id), params)
-		select {
-		case r := <-ch:
-			if r.err == nil {
-				template := r.result
-				params.LongPollID = template.LongPollID
-				p.log.Println("shard", id, template.Height)
-
-				err := p.Job.ProcessShardTemplate(template, id)
-				if err != nil {
-					p.log.Println("ERR", err)
-				}
-
-			} else {
-				p.log.Println("ERR", r.err)
-				time.Sleep(getTemplateInverval)
-			}
-		case <-ctx.Done():
+		if !p.fetchShardTemplateOnce(ctx, id, params) {
 			p.log.Println("stop fetching template shard", id)
 			return
 		}
 	}
 }
 
+// fetchShardTemplateOnce returns false if the shard is removed.
+func (p *Poller) fetchShardTemplateOnce(ctx context.Context, id uint32, params *jaxjson.TemplateRequest) bool {
+	rpcClient, err := rpcclient.New(p.rpcConf, nil)
+	if err != nil {
+		p.log.Println("ERR:", err)
+		time.Sleep(getTemplateInverval)
+		return true
+	}
+	defer rpcClient.Shutdown() // the long poll goroutine exits with the request, the channel is buffered
+
+	ch := GetShardBlockTemplateAsync(rpcClient.ForShard(id), params)
+	select {
+	case r := <-ch:
+		if ctx.Err() != nil { // shard was removed while waiting for template
+			return false
+		}
+		if r.err != nil {
+			p.log.Println("ERR", r.err)
+			time.Sleep(getTemplateInverval)
+			return true
+		}
+		template := r.result
+		params.LongPollID = template.LongPollID
+		p.log.Println("shard", id, template.Height)
+		return p.processShardTemplate(ctx, template, id)
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Poller) removeShard(id uint32, cancel context.CancelFunc) {
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+
+	cancel()
+	if err := p.Job.RemoveShard(id); err != nil {
+		p.log.Println("ERR", err)
+	}
+}
+
+// processShardTemplate returns false if the shard is removed, the template is dropped then.
+func (p *Poller) processShardTemplate(ctx context.Context, template *jaxjson.GetShardBlockTemplateResult, id uint32) bool {
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+	if err := p.Job.ProcessShardTemplate(template, id); err != nil {
+		p.log.Println("ERR", err)
+	}
+	return true
+}
+
 type resShardBlockTemplate struct {
 	result *jaxjson.GetShardBlockTemplateResult
 	err    error
 }
 
 func GetShardBlockTemplateAsync(rpc *rpcclient.Client, reqData *jaxjson.TemplateRequest) chan resShardBlockTemplate {
-	ch := make(chan resShardBlockTemplate)
+	ch := make(chan resShardBlockTemplate, 1) // the receiver may be gone
 	go func() {
 		result, err := rpc.GetShardBlockTemplateAsync(reqData).Receive()
 		ch <- resShardBlockTemplate{result, err}
@@ -0,0 +1,76 @@
+package mining
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+	"gitlab.com/jaxnet/jaxnetd/types/jaxjson"
+)
+
+func TestPollerRemoveShard(t *testing.T) {
+	m := testMiner(t)
+	if err := m.Job.ProcessBeaconTemplate(testBeaconTemplate(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPoller(m)
+
+	value := int64(1000)
+	template := &jaxjson.GetShardBlockTemplateResult{
+		Bits:              "1d00ffff",
+		Target:            "00000000ffff0000000000000000000000000000000000000000000000000000",
+		ChainWeight:       "0",
+		CoinbaseValue:     &value,
+		Height:            1,
+		PreviousHash:      chainhash.Hash{1}.String(),
+		PrevBlocksMMRRoot: chainhash.Hash{2}.String(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.True(t, p.processShardTemplate(ctx, template, 1))
+	assert.Len(t, m.Job.CurrentSnapshot().ShardsTargets, 1)
+
+	p.removeShard(1, cancel)
+	assert.Empty(t, m.Job.CurrentSnapshot().ShardsTargets)
+
+	// the template fetched before the removal
+	assert.False(t, p.processShardTemplate(ctx, template, 1))
+	assert.Empty(t, m.Job.CurrentSnapshot().ShardsTargets)
+}
+
+func TestPollerCancelLongPoll(t *testing.T) {
+	release := make(chan struct{})
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // long poll
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}))
+	defer node.Close()
+
+	m := testMiner(t)
+	m.rpcConf = jaxRPCConfig("http://user:pass@"+node.Listener.Addr().String(), &network.TestNet)
+	p := NewPoller(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, p.fetchShardTemplateOnce(ctx, 1, &jaxjson.TemplateRequest{}))
+
+	// the long poll goroutine exits when the request is done
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for longPollRunning() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, longPollRunning())
+}
+
+func longPollRunning() bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "GetShardBlockTemplateAsync")
+}
@@ -2,6 +2,7 @@ package mining
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	btcwire "github.com/btcsuite/btcd/wire"
 	"github.com/inc4/jax/mining/job"
@@ -11,27 +12,63 @@ import (
 	"gitlab.com/jaxnet/jaxnetd/types/pow"
 	"gitlab.com/jaxnet/jaxnetd/types/wire"
 	"math/big"
+	"runtime"
+	"sync"
 	"time"
 )
 
 type MinerResult struct {
-	ShardId     uint32
-	Amount      int64
+	IsBtc   bool // bitcoin block, ShardId is meaningless
+	ShardId uint32
+	Amount  int64 // total reward of the block including the burnt and time-locked outputs
+	// Spendable is the part of Amount paid to the pool address, the pool can spend it once the block matures.
+	Spendable   int64
 	BlockHeight int64
 	BlockHash   chainhash.Hash
 	BlockTime   time.Time
-	Err         error
+	RawBlock    []byte // serialized submitted block
+	// Err is the submit error. The block is submitted asynchronously if the miner has the submitter (see StartSubmitter),
+	// then the result is Pending and the final one with the error is passed to SubmitConfig.OnResult.
+	Err     error
+	Pending bool
 }
 
-func (m *Miner) Solution(btcHeader, coinbaseTx []byte, txs []string) (results []*MinerResult, err error) {
+var (
+	ErrLowDifficulty = errors.New("low difficulty share")
+	ErrInvalidShare  = errors.New("invalid share")
+)
+
+// ShareVerdict is the result of the share check.
+type ShareVerdict struct {
+	Accepted bool
+	// Reason is why the share is rejected: ErrLowDifficulty, ErrInvalidShare, job.ErrStaleJob or job.ErrUnknownJob.
+	Reason     error
+	Hash       chainhash.Hash
+	Difficulty float64 // difficulty achieved by the share
+
+	// chains solved by the share, blocks are submitted for them
+	Btc     bool
+	Beacon  bool
+	Shards  []uint32
+	Results []*MinerResult
+}
+
+// Share checks the share against the job snapshot with jobID (see job.CoinBaseTx.JobID).
+// The share is accepted if its hash meets shareTarget or it solves any chain.
+func (m *Miner) Share(jobID uint64, btcHeader, coinbaseTx []byte, txs []string, shareTarget *big.Int) *ShareVerdict {
+	snapshot, err := m.Job.GetSnapshot(jobID)
+	if err != nil {
+		return &ShareVerdict{Reason: err}
+	}
+
 	header := &btcwire.BlockHeader{}
 	if err = header.Deserialize(bytes.NewReader(btcHeader)); err != nil {
-		return
+		return &ShareVerdict{Reason: fmt.Errorf("%w: can't decode header: %v", ErrInvalidShare, err)}
 	}
 
 	tx := &wire.MsgTx{}
 	if err = tx.Deserialize(bytes.NewReader(coinbaseTx)); err != nil {
-		return
+		return &ShareVerdict{Reason: fmt.Errorf("%w: can't decode coinbase: %v", ErrInvalidShare, err)}
 	}
 
 	txHashes := make([]chainhash.Hash, len(txs)+1)
@@ -39,12 +76,63 @@ func (m *Miner) Solution(btcHeader, coinbaseTx []byte, txs []string) (results []
 	for i, hashHex := range txs {
 		hash, err := chainhash.NewHashFromStr(hashHex)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode tx hash %v: %w", hashHex, err)
+			return &ShareVerdict{Reason: fmt.Errorf("%w: failed to decode tx hash %v: %v", ErrInvalidShare, hashHex, err)}
 		}
 		txHashes[i+1] = *hash
 	}
 
-	results = m.CheckSolution(header, tx, txHashes)
+	return m.checkShare(snapshot, header, tx, chainhash.BuildCoinbaseMerkleTreeProof(txHashes), shareTarget)
+}
+
+// ShareRequest is a share submitted by the miner, see Miner.Share.
+type ShareRequest struct {
+	JobID       uint64
+	BtcHeader   []byte
+	CoinbaseTx  []byte
+	Txs         []string
+	ShareTarget *big.Int
+}
+
+// Shares checks the batch of shares on at most workers goroutines (runtime.NumCPU() if workers <= 0).
+// Verdicts are in the same order as shares.
+func (m *Miner) Shares(shares []ShareRequest, workers int) []*ShareVerdict {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(shares) {
+		workers = len(shares)
+	}
+
+	verdicts := make([]*ShareVerdict, len(shares))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				s := &shares[i]
+				verdicts[i] = m.Share(s.JobID, s.BtcHeader, s.CoinbaseTx, s.Txs, s.ShareTarget)
+			}
+		}()
+	}
+	for i := range shares {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return verdicts
+}
+
+// Solution checks the solution against the job snapshot with jobID (see job.CoinBaseTx.JobID).
+// It returns job.ErrStaleJob or job.ErrUnknownJob if the snapshot is not in the job history.
+func (m *Miner) Solution(jobID uint64, btcHeader, coinbaseTx []byte, txs []string) (results []*MinerResult, err error) {
+	verdict := m.Share(jobID, btcHeader, coinbaseTx, txs, nil)
+	if verdict.Reason != nil && !errors.Is(verdict.Reason, ErrLowDifficulty) {
+		return nil, verdict.Reason
+	}
+
+	results = verdict.Results
 	for _, r := range results {
 		if r.Err == nil {
 			continue
@@ -59,81 +147,135 @@ func (m *Miner) Solution(btcHeader, coinbaseTx []byte, txs []string) (results []
 }
 
 func (m *Miner) CheckSolution(btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txHashes []chainhash.Hash) (results []*MinerResult) {
-	m.Job.RLock()
-	defer m.Job.RUnlock()
+	return m.CheckSolutionWithProof(btcHeader, coinbaseTx, chainhash.BuildCoinbaseMerkleTreeProof(txHashes))
+}
 
-	btcAux := wire.BTCBlockAux{
-		Version:     btcHeader.Version,
-		PrevBlock:   chainhash.Hash(btcHeader.PrevBlock),
-		MerkleRoot:  chainhash.Hash(btcHeader.MerkleRoot),
-		Timestamp:   btcHeader.Timestamp,
-		Bits:        btcHeader.Bits,
-		Nonce:       btcHeader.Nonce,
-		CoinbaseAux: wire.CoinbaseAux{Tx: *coinbaseTx, TxMerkleProof: chainhash.BuildCoinbaseMerkleTreeProof(txHashes)},
-	}
+// CheckSolutionWithProof is the same as CheckSolution, but takes the coinbase merkle branch
+// instead of the hashes of all block transactions.
+func (m *Miner) CheckSolutionWithProof(btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txMerkleProof []chainhash.Hash) (results []*MinerResult) {
+	return m.CheckShareWithProof(btcHeader, coinbaseTx, txMerkleProof, nil).Results
+}
 
-	beaconBlock := m.Job.Beacon.Block.Copy()
-	beaconBlock.Header.BeaconHeader().SetBTCAux(btcAux)
+// CheckSolutionOnSnapshot is the same as CheckSolutionWithProof, but checks the solution against the given job snapshot
+// instead of the current one.
+func (m *Miner) CheckSolutionOnSnapshot(snapshot *job.Snapshot, btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txMerkleProof []chainhash.Hash) (results []*MinerResult) {
+	return m.checkShare(snapshot, btcHeader, coinbaseTx, txMerkleProof, nil).Results
+}
+
+// CheckShare is the same as CheckSolution, but also checks the share against shareTarget and returns the verdict.
+func (m *Miner) CheckShare(btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txHashes []chainhash.Hash, shareTarget *big.Int) *ShareVerdict {
+	return m.CheckShareWithProof(btcHeader, coinbaseTx, chainhash.BuildCoinbaseMerkleTreeProof(txHashes), shareTarget)
+}
+
+// CheckShareWithProof is the same as CheckShare, but takes the coinbase merkle branch.
+func (m *Miner) CheckShareWithProof(btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txMerkleProof []chainhash.Hash, shareTarget *big.Int) *ShareVerdict {
+	snapshot := m.Job.CurrentSnapshot()
+	if snapshot == nil {
+		return &ShareVerdict{Reason: fmt.Errorf("%w: no job yet", job.ErrUnknownJob)}
+	}
+	return m.checkShare(snapshot, btcHeader, coinbaseTx, txMerkleProof, shareTarget)
+}
 
-	hash := beaconBlock.Header.BeaconHeader().PoWHash()
+func (m *Miner) checkShare(snapshot *job.Snapshot, btcHeader *btcwire.BlockHeader, coinbaseTx *wire.MsgTx, txMerkleProof []chainhash.Hash, shareTarget *big.Int) *ShareVerdict {
+	// The beacon PoW hash is the hash of the bitcoin header, so blocks are built only for solved chains.
+	hash := chainhash.Hash(btcHeader.BlockHash())
 	hashBigInt := pow.HashToBig(&hash)
 
-	if m.checkHash(hashBigInt, m.Job.Beacon) {
-		result := m.newMinerResult(beaconBlock, 0, m.Job.Beacon.Height)
-		results = append(results, result)
+	verdict := &ShareVerdict{
+		Hash:       hash,
+		Difficulty: TargetToDifficulty(hashBigInt),
 	}
 
-	for _, t := range m.Job.ShardsTargets {
-		if m.checkHash(hashBigInt, t) {
-			shardBlock := t.Block.Copy()
-			coinbaseAux := wire.CoinbaseAux{}.FromBlock(beaconBlock, true)
+	if result := m.checkBtcSolution(btcHeader, coinbaseTx, hashBigInt); result != nil {
+		verdict.Btc = true
+		verdict.Results = append(verdict.Results, result)
+	}
+
+	var beaconBlock *wire.MsgBlock
+	getBeaconBlock := func() *wire.MsgBlock {
+		if beaconBlock == nil {
+			beaconBlock = withHeaderCopy(snapshot.Beacon.Block)
+			beaconBlock.Header.BeaconHeader().SetBTCAux(wire.BTCBlockAux{
+				Version:     btcHeader.Version,
+				PrevBlock:   chainhash.Hash(btcHeader.PrevBlock),
+				MerkleRoot:  chainhash.Hash(btcHeader.MerkleRoot),
+				Timestamp:   btcHeader.Timestamp,
+				Bits:        btcHeader.Bits,
+				Nonce:       btcHeader.Nonce,
+				CoinbaseAux: wire.CoinbaseAux{Tx: *coinbaseTx.Copy(), TxMerkleProof: txMerkleProof},
+			})
+		}
+		return beaconBlock
+	}
 
-			shardBlock.Header.SetBeaconHeader(beaconBlock.Header.BeaconHeader(), coinbaseAux)
+	slot, sorting := m.hashSortingSlot(hashBigInt)
+	if m.checkHash(hashBigInt, slot, sorting, snapshot.Beacon) {
+		result := m.newMinerResult(getBeaconBlock(), 0, snapshot.Beacon.Height)
+		verdict.Beacon = true
+		verdict.Results = append(verdict.Results, result)
+	}
 
-			result := m.newMinerResult(shardBlock, t.ShardID, t.Height)
-			results = append(results, result)
-		} else {
-			break // Other targets are higher than current one.
+	// Every shard is checked: the hash may miss the hardest targets or the slot of some shards and still solve others.
+	for _, t := range snapshot.ShardsTargets {
+		if !m.checkHash(hashBigInt, slot, sorting, t) {
+			continue
 		}
+		beacon := getBeaconBlock()
+		shardBlock := withHeaderCopy(t.Block)
+		coinbaseAux := wire.CoinbaseAux{}.FromBlock(beacon, true)
+
+		shardBlock.Header.SetBeaconHeader(beacon.Header.BeaconHeader(), coinbaseAux)
+
+		result := m.newMinerResult(shardBlock, t.ShardID, t.Height)
+		verdict.Shards = append(verdict.Shards, t.ShardID)
+		verdict.Results = append(verdict.Results, result)
 	}
 
-	return
+	verdict.Accepted = len(verdict.Results) > 0 || shareTarget != nil && hashBigInt.Cmp(shareTarget) <= 0
+	if !verdict.Accepted {
+		verdict.Reason = ErrLowDifficulty
+	}
+	return verdict
 }
 
-func (m *Miner) submitBlock(block *wire.MsgBlock, shardID uint32) error {
-	wireBlock := jaxutil.NewBlock(block)
-	// TODO we need new client due to bug in jaxnetd/network/rpcclient
-	rpcClient, err := rpcclient.New(m.rpcConf, nil)
-	if err != nil {
-		return err
-	}
-	return rpcClient.ForShard(shardID).SubmitBlock(wireBlock, nil)
+// withHeaderCopy returns the block with the copied header and the same transactions.
+// Snapshot transactions are never modified, so they are shared instead of copied for every solved share.
+func withHeaderCopy(block *wire.MsgBlock) *wire.MsgBlock {
+	return &wire.MsgBlock{Header: block.Header.Copy(), Transactions: block.Transactions}
 }
 
 func (m *Miner) newMinerResult(block *wire.MsgBlock, shardID uint32, height int64) *MinerResult {
-	err := m.submitBlock(block, shardID)
-	if err != nil {
-		err = fmt.Errorf("can't submit block (shardId=%v): %w", shardID, err)
-	}
+	raw := bytes.NewBuffer(make([]byte, 0, block.SerializeSize()))
+	_ = block.Serialize(raw)
 
-	return &MinerResult{
+	result := &MinerResult{
 		ShardId:     shardID,
 		Amount:      block.Transactions[0].TxOut[1].Value + block.Transactions[0].TxOut[2].Value,
+		Spendable:   m.Job.Config.PoolReward(block.Transactions[0], false),
 		BlockHeight: height,
 		BlockHash:   block.BlockHash(),
 		BlockTime:   block.Header.Timestamp(),
-		Err:         err,
+		RawBlock:    raw.Bytes(),
 	}
+	m.submit(result, func(client *rpcclient.Client) error {
+		return client.ForShard(shardID).SubmitBlock(jaxutil.NewBlock(block), nil)
+	})
+	return result
 }
 
-func (m *Miner) checkHash(hash *big.Int, t *job.Task) bool {
-	if hash.Cmp(t.Target) > 0 {
-		fmt.Println("hash < target for shardId", t.ShardID)
-		return false
+// hashSortingSlot returns the hash sorting slot of the hash, sorting is false if the network doesn't use hash sorting.
+func (m *Miner) hashSortingSlot(hash *big.Int) (slot uint32, sorting bool) {
+	params := m.Job.Config.JaxNetParams.PowParams
+	if !params.HashSorting {
+		return 0, false
 	}
-	if m.Job.Config.JaxNetParams.PowParams.HashSorting && !pow.ValidateHashSortingRule(hash, m.Job.Config.JaxNetParams.PowParams.HashSortingSlotNumber, t.ShardID) {
-		// fmt.Println("ValidateHashSortingRule failed for shardId", t.ShardID)
+	return pow.HashSortingLastBits(new(big.Int).Set(hash), params.HashSortingSlotNumber), true // it modifies the hash
+}
+
+// checkHash checks the hash meets the task target and, with hash sorting, the slot belongs to the task chain.
+func (m *Miner) checkHash(hash *big.Int, slot uint32, sorting bool, t *job.Task) bool {
+	if sorting && slot != t.ShardID%m.Job.Config.JaxNetParams.PowParams.HashSortingSlotNumber {
 		return false
 	}
-	return true
+	return hash.Cmp(t.Target) <= 0
 }
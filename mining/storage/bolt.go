@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	blocksBucket = []byte("blocks")
+	btcChain     = []byte("btc")
+	shardPrefix  = []byte("shard")
+)
+
+// BoltStore is the Store in the embedded bbolt file.
+// Blocks of every chain are in their own bucket keyed by height and hash, so height ranges are cursor scans.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var _ Store = (*BoltStore)(nil)
+
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open %v: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(blocksBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) SaveBlock(block *Block) error {
+	if block.Height < 0 {
+		return fmt.Errorf("invalid block height %v", block.Height)
+	}
+	hash, err := hex.DecodeString(block.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid block hash %v: %w", block.Hash, err)
+	}
+	value, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		chain, err := tx.Bucket(blocksBucket).CreateBucketIfNotExists(chainName(block.IsBtc, block.ShardID))
+		if err != nil {
+			return err
+		}
+		return chain.Put(append(heightKey(block.Height), hash...), value)
+	})
+}
+
+func (s *BoltStore) BlocksByHeight(from, to int64) (blocks []*Block, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(blocksBucket).ForEach(func(name, _ []byte) error {
+			chainBlocks, err := scanHeights(tx.Bucket(blocksBucket).Bucket(name), from, to)
+			blocks = append(blocks, chainBlocks...)
+			return err
+		})
+	})
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].Height < blocks[j].Height })
+	return
+}
+
+func (s *BoltStore) BlocksByShard(shardID uint32, from, to int64) ([]*Block, error) {
+	return s.chainBlocks(chainName(false, shardID), from, to)
+}
+
+func (s *BoltStore) BtcBlocks(from, to int64) ([]*Block, error) {
+	return s.chainBlocks(btcChain, from, to)
+}
+
+func (s *BoltStore) chainBlocks(name []byte, from, to int64) (blocks []*Block, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		blocks, err = scanHeights(tx.Bucket(blocksBucket).Bucket(name), from, to)
+		return err
+	})
+	return
+}
+
+func scanHeights(chain *bolt.Bucket, from, to int64) (blocks []*Block, err error) {
+	if chain == nil || to < from || to < 0 {
+		return nil, nil
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	c := chain.Cursor()
+	end := heightKey(to)
+	for k, v := c.Seek(heightKey(from)); k != nil && bytes.Compare(k[:8], end) <= 0; k, v = c.Next() {
+		block := &Block{}
+		if err := json.Unmarshal(v, block); err != nil {
+			return nil, fmt.Errorf("can't decode block %x: %w", k, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func chainName(isBtc bool, shardID uint32) []byte {
+	if isBtc {
+		return btcChain
+	}
+	name := make([]byte, len(shardPrefix)+4)
+	copy(name, shardPrefix)
+	binary.BigEndian.PutUint32(name[len(shardPrefix):], shardID)
+	return name
+}
+
+// heightKey is big endian, so keys are sorted by height.
+func heightKey(height int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
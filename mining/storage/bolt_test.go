@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.db")
+	store, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []*mining.MinerResult{
+		{ShardId: 0, BlockHeight: 10, BlockHash: chainhash.Hash{1}, Amount: 100, RawBlock: []byte{1, 2}},
+		{ShardId: 1, BlockHeight: 5, BlockHash: chainhash.Hash{2}, Amount: 20},
+		{ShardId: 1, BlockHeight: 7, BlockHash: chainhash.Hash{3}, Amount: 20, Err: errors.New("rejected")},
+		{ShardId: 1, BlockHeight: 7, BlockHash: chainhash.Hash{4}, Amount: 20},
+		{IsBtc: true, BlockHeight: 700000, BlockHash: chainhash.Hash{5}, Amount: 625000000},
+	}
+	if err := SaveResults(store, results); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, store.Close())
+
+	store, err = OpenBolt(path) // persisted
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	blocks, err := store.BlocksByShard(1, 6, 100)
+	assert.NoError(t, err)
+	if assert.Len(t, blocks, 2) {
+		assert.Equal(t, int64(7), blocks[0].Height)
+		assert.Equal(t, "rejected", blocks[0].SubmitError)
+		assert.Equal(t, chainhash.Hash{4}.String(), blocks[1].Hash)
+	}
+
+	blocks, err = store.BlocksByShard(0, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, blocks, 1) {
+		assert.Equal(t, "0102", blocks[0].RawHex)
+		assert.Equal(t, int64(100), blocks[0].Amount)
+		assert.WithinDuration(t, time.Now(), blocks[0].Time, time.Minute)
+	}
+
+	blocks, err = store.BlocksByHeight(0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 4)
+	for i := 1; i < len(blocks); i++ {
+		assert.LessOrEqual(t, blocks[i-1].Height, blocks[i].Height)
+	}
+
+	blocks, err = store.BtcBlocks(0, 1<<62)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 1)
+
+	blocks, err = store.BlocksByShard(2, 0, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, blocks)
+}
@@ -0,0 +1,65 @@
+// Package storage keeps the blocks submitted by the miner.
+package storage
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/inc4/jax/mining"
+)
+
+// Block is the submitted block record.
+type Block struct {
+	IsBtc       bool   `json:"is_btc"`
+	ShardID     uint32 `json:"shard_id"` // 0 is beacon, meaningless for bitcoin blocks
+	Height      int64  `json:"height"`
+	Hash        string `json:"hash"`
+	Amount      int64  `json:"amount"`
+	RawHex      string `json:"raw"`
+	SubmitError string `json:"submit_error,omitempty"`
+	// Time is when the block was submitted.
+	Time time.Time `json:"time"`
+}
+
+func NewBlock(r *mining.MinerResult, submitted time.Time) *Block {
+	b := &Block{
+		IsBtc:   r.IsBtc,
+		ShardID: r.ShardId,
+		Height:  r.BlockHeight,
+		Hash:    r.BlockHash.String(),
+		Amount:  r.Amount,
+		RawHex:  hex.EncodeToString(r.RawBlock),
+		Time:    submitted,
+	}
+	if r.Err != nil {
+		b.SubmitError = r.Err.Error()
+	}
+	return b
+}
+
+// Store is the storage of the submitted blocks. Height ranges are inclusive.
+type Store interface {
+	SaveBlock(block *Block) error
+	// BlocksByHeight returns blocks of all chains sorted by height.
+	BlocksByHeight(from, to int64) ([]*Block, error)
+	// BlocksByShard returns beacon (shardID 0) or shard blocks sorted by height.
+	BlocksByShard(shardID uint32, from, to int64) ([]*Block, error)
+	// BtcBlocks returns bitcoin blocks sorted by height.
+	BtcBlocks(from, to int64) ([]*Block, error)
+	Close() error
+}
+
+// SaveResults saves the blocks of the check results, it fits stratum.Config.OnShare through a closure.
+// Pending results are skipped, with the miner submitter save them from SubmitConfig.OnResult.
+func SaveResults(store Store, results []*mining.MinerResult) error {
+	now := time.Now()
+	for _, r := range results {
+		if r.Pending {
+			continue
+		}
+		if err := store.SaveBlock(NewBlock(r, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package stratum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	btcchainhash "github.com/btcsuite/btcd/chaincfg/chainhash"
+	btcwire "github.com/btcsuite/btcd/wire"
+	"github.com/inc4/jax/mining/job"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+// Job is a unit of work sent to miners with mining.notify.
+type Job struct {
+	ID string
+	*job.BitcoinJob
+
+	sync.Mutex
+	submitted map[string]struct{}
+}
+
+func newJob(id string, bj *job.BitcoinJob) *Job {
+	return &Job{
+		ID:         id,
+		BitcoinJob: bj,
+		submitted:  make(map[string]struct{}),
+	}
+}
+
+// NotifyParams returns params of the mining.notify message.
+func (j *Job) NotifyParams() []interface{} {
+	branch := make([]string, len(j.MerkleBranch))
+	for i, h := range j.MerkleBranch {
+		branch[i] = hex.EncodeToString(h[:])
+	}
+	return []interface{}{
+		j.ID,
+		stratumPrevHash(j.PrevHash),
+		hex.EncodeToString(j.Coinbase.Part1),
+		hex.EncodeToString(j.Coinbase.Part2),
+		branch,
+		uint32Hex(uint32(j.Version)),
+		uint32Hex(j.Bits),
+		uint32Hex(j.Time),
+		j.CleanJobs,
+	}
+}
+
+// BuildCoinbase assembles the coinbase transaction from the job parts and extranonces.
+func (j *Job) BuildCoinbase(extraNonce1, extraNonce2 []byte) []byte {
+	coinbase := make([]byte, 0, len(j.Coinbase.Part1)+len(extraNonce1)+len(extraNonce2)+len(j.Coinbase.Part2))
+	coinbase = append(coinbase, j.Coinbase.Part1...)
+	coinbase = append(coinbase, extraNonce1...)
+	coinbase = append(coinbase, extraNonce2...)
+	return append(coinbase, j.Coinbase.Part2...)
+}
+
+// Header assembles the bitcoin block header of the share.
+func (j *Job) Header(coinbase []byte, ntime, nonce uint32) *btcwire.BlockHeader {
+	root := chainhash.CoinbaseMerkleTreeProofRoot(chainhash.DoubleHashH(coinbase), j.MerkleBranch)
+	return &btcwire.BlockHeader{
+		Version:    j.Version,
+		PrevBlock:  j.PrevHash,
+		MerkleRoot: btcchainhash.Hash(root),
+		Timestamp:  time.Unix(int64(ntime), 0),
+		Bits:       j.Bits,
+		Nonce:      nonce,
+	}
+}
+
+// registerShare returns false if the same share was already submitted.
+func (j *Job) registerShare(extraNonce1, extraNonce2 []byte, ntime, nonce uint32) bool {
+	key := fmt.Sprintf("%x:%x:%x:%x", extraNonce1, extraNonce2, ntime, nonce)
+
+	j.Lock()
+	defer j.Unlock()
+
+	if _, ok := j.submitted[key]; ok {
+		return false
+	}
+	j.submitted[key] = struct{}{}
+	return true
+}
+
+// stratumPrevHash encodes hash as stratum expects: internal byte order with every 4-byte word reversed.
+func stratumPrevHash(hash btcchainhash.Hash) string {
+	var buf [btcchainhash.HashSize]byte
+	for i := 0; i < btcchainhash.HashSize; i += 4 {
+		binary.BigEndian.PutUint32(buf[i:], binary.LittleEndian.Uint32(hash[i:]))
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+func uint32Hex(v uint32) string {
+	return fmt.Sprintf("%08x", v)
+}
+
+func parseUint32Hex(s string) (uint32, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 4 {
+		return 0, fmt.Errorf("expected 4 bytes, got %v", len(b))
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func serializeHeader(header *btcwire.BlockHeader) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, btcwire.MaxBlockHeaderPayload))
+	_ = header.Serialize(buf)
+	return buf.Bytes()
+}
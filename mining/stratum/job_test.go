@@ -0,0 +1,48 @@
+package stratum
+
+import (
+	"testing"
+
+	btcchainhash "github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/inc4/jax/mining/job"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+func TestStratumPrevHash(t *testing.T) {
+	hash, _ := btcchainhash.NewHashFromStr("0000000000000000030a4493a1e5fef5de7fe6ceeee9f5d77c7feb4ce051a9d0")
+	assert.Equal(t, "e051a9d07c7feb4ceee9f5d7de7fe6cea1e5fef5030a44930000000000000000", stratumPrevHash(*hash))
+}
+
+func TestJobHeader(t *testing.T) {
+	txs := []string{
+		"3b1e4a4d9bfe4fb3e2d9a4bd70e7a0e9f0c2f8fe4f4b1d9f3ac0b8e2b4e9a001",
+		"3b1e4a4d9bfe4fb3e2d9a4bd70e7a0e9f0c2f8fe4f4b1d9f3ac0b8e2b4e9a002",
+		"3b1e4a4d9bfe4fb3e2d9a4bd70e7a0e9f0c2f8fe4f4b1d9f3ac0b8e2b4e9a003",
+	}
+	branch, err := job.MerkleBranch(txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newJob("1", &job.BitcoinJob{
+		Coinbase:     &job.CoinBaseTx{Part1: []byte{1, 2}, Part2: []byte{3, 4}},
+		MerkleBranch: branch,
+		TxHashes:     txs,
+		Version:      0x20000000,
+		Bits:         0x1d00ffff,
+	})
+
+	coinbase := j.BuildCoinbase([]byte{5, 6, 7, 8}, []byte{9, 10, 11, 12})
+	assert.Equal(t, []byte{1, 2, 5, 6, 7, 8, 9, 10, 11, 12, 3, 4}, coinbase)
+
+	hashes := []chainhash.Hash{chainhash.DoubleHashH(coinbase)}
+	for _, tx := range txs {
+		h, _ := chainhash.NewHashFromStr(tx)
+		hashes = append(hashes, *h)
+	}
+
+	header := j.Header(coinbase, 1, 2)
+	assert.Equal(t, chainhash.MerkleTreeRoot(hashes), chainhash.Hash(header.MerkleRoot))
+	assert.Equal(t, uint32(2), header.Nonce)
+	assert.Equal(t, int64(1), header.Timestamp.Unix())
+}
@@ -0,0 +1,90 @@
+package stratum
+
+import (
+	"encoding/json"
+)
+
+// Stratum error codes as used by the most of pool software.
+const (
+	ErrCodeOther          = 20
+	ErrCodeJobNotFound    = 21
+	ErrCodeDuplicateShare = 22
+	ErrCodeLowDifficulty  = 23
+	ErrCodeUnauthorized   = 24
+	ErrCodeNotSubscribed  = 25
+)
+
+type Request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type Response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	Error  *Error      `json:"error"`
+}
+
+type Notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type Error struct {
+	Code    int
+	Message string
+}
+
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// MarshalJSON encodes error in stratum format: [code, message, traceback]
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Code, e.Message, nil})
+}
+
+func (e *Error) UnmarshalJSON(data []byte) error {
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) > 0 {
+		if code, ok := raw[0].(float64); ok {
+			e.Code = int(code)
+		}
+	}
+	if len(raw) > 1 {
+		if msg, ok := raw[1].(string); ok {
+			e.Message = msg
+		}
+	}
+	return nil
+}
+
+var (
+	errJobNotFound    = NewError(ErrCodeJobNotFound, "job not found")
+	errStaleJob       = NewError(ErrCodeJobNotFound, "stale job")
+	errDuplicateShare = NewError(ErrCodeDuplicateShare, "duplicate share")
+	errLowDifficulty  = NewError(ErrCodeLowDifficulty, "low difficulty share")
+	errUnauthorized   = NewError(ErrCodeUnauthorized, "unauthorized worker")
+	errNotSubscribed  = NewError(ErrCodeNotSubscribed, "not subscribed")
+)
+
+func parseParams(params []json.RawMessage, values ...interface{}) *Error {
+	if len(params) < len(values) {
+		return NewError(ErrCodeOther, "not enough params")
+	}
+	for i, v := range values {
+		if err := json.Unmarshal(params[i], v); err != nil {
+			return NewError(ErrCodeOther, "invalid params")
+		}
+	}
+	return nil
+}
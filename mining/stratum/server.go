@@ -0,0 +1,220 @@
+package stratum
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"github.com/inc4/jax/mining/extranonce"
+	"github.com/inc4/jax/mining/job"
+	"github.com/inc4/jax/mining/vardiff"
+)
+
+const (
+	defaultDifficulty   = 1
+	defaultWriteTimeout = 10 * time.Second
+	maxJobs             = 32 // shares for older jobs are rejected as stale
+)
+
+type Config struct {
+	// Difficulty is a share difficulty sent with mining.set_difficulty.
+	Difficulty float64
+	// VarDiff adjusts the difficulty of every session to its share rate starting from Difficulty.
+	// The difficulty is fixed if nil.
+	VarDiff *vardiff.Config
+	// Authorize validates worker credentials. All workers are accepted if nil.
+	Authorize func(worker, password string) bool
+	// OnShare is called for every accepted share with the difficulty it was checked against. Optional.
+	OnShare func(worker string, difficulty float64, verdict *mining.ShareVerdict)
+	// ExtraNonces gives extranonce1 to the sessions, it may be shared by several servers.
+	// Its size must match the job ExtraNonce1Size. Allocator without reserved values is used if nil.
+	ExtraNonces *extranonce.Allocator
+	// WriteTimeout limits sending of one message, slow miners are disconnected. defaultWriteTimeout if zero.
+	WriteTimeout time.Duration
+}
+
+type Server struct {
+	miner  *mining.Miner
+	config Config
+	log    *log.Logger
+
+	extraNonce2Size int // space reserved in the bitcoin coinbase, see job.Configuration
+
+	sync.RWMutex
+	listener   net.Listener
+	sessions   map[*session]struct{}
+	jobs       map[string]*Job
+	jobIDs     []string // in order of creation
+	currentJob *Job
+
+	jobCounter uint64
+	done       chan struct{} // closed by Close under the lock, no sessions are added after that
+	closeOnce  sync.Once
+}
+
+func NewServer(miner *mining.Miner, config Config) (*Server, error) {
+	if config.Difficulty <= 0 {
+		config.Difficulty = defaultDifficulty
+	}
+	if config.VarDiff != nil {
+		if _, err := vardiff.New(*config.VarDiff, config.Difficulty, time.Now()); err != nil {
+			return nil, fmt.Errorf("invalid vardiff config: %w", err)
+		}
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+	extraNonce1Size, extraNonce2Size := miner.Job.Config.ExtraNonce1Size, miner.Job.Config.ExtraNonce2Size
+	if extraNonce2Size <= 0 {
+		return nil, fmt.Errorf("invalid extranonce2 size %v", extraNonce2Size)
+	}
+	if config.ExtraNonces == nil {
+		var err error
+		if config.ExtraNonces, err = extranonce.NewAllocator(extraNonce1Size, 0); err != nil {
+			return nil, err
+		}
+	}
+	if config.ExtraNonces.Size() != extraNonce1Size {
+		return nil, fmt.Errorf("extranonce1 size must be %v", extraNonce1Size)
+	}
+	return &Server{
+		miner:           miner,
+		config:          config,
+		log:             log.Default(),
+		extraNonce2Size: extraNonce2Size,
+		sessions:        make(map[*session]struct{}),
+		jobs:            make(map[string]*Job),
+		done:            make(chan struct{}),
+	}, nil
+}
+
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+func (s *Server) Serve(l net.Listener) error {
+	s.Lock()
+	select {
+	case <-s.done:
+		s.Unlock()
+		_ = l.Close()
+		return nil
+	default:
+	}
+	s.listener = l
+	s.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops the server and disconnects the miners. It's safe to call it more than once.
+func (s *Server) Close() (err error) {
+	s.closeOnce.Do(func() {
+		s.Lock()
+		close(s.done)
+		sessions := make([]*session, 0, len(s.sessions))
+		for sess := range s.sessions {
+			sessions = append(sessions, sess)
+		}
+		listener := s.listener
+		s.Unlock()
+
+		for _, sess := range sessions {
+			sess.close()
+		}
+		if listener != nil {
+			err = listener.Close()
+		}
+	})
+	return
+}
+
+func (s *Server) SetJob(bj *job.BitcoinJob) {
+	s.Lock()
+	s.jobCounter++
+	j := newJob(fmt.Sprintf("%x", s.jobCounter), bj)
+	if j.CleanJobs {
+		s.jobs = make(map[string]*Job)
+		s.jobIDs = s.jobIDs[:0]
+	}
+	if len(s.jobIDs) == maxJobs {
+		delete(s.jobs, s.jobIDs[0])
+		s.jobIDs = append(s.jobIDs[:0], s.jobIDs[1:]...)
+	}
+	s.jobs[j.ID] = j
+	s.jobIDs = append(s.jobIDs, j.ID)
+	s.currentJob = j
+
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.Unlock()
+
+	for _, sess := range sessions {
+		sess.queueJob(j)
+	}
+}
+
+// CurrentJob returns the latest job or nil if there is no template yet.
+func (s *Server) CurrentJob() *Job {
+	s.RLock()
+	defer s.RUnlock()
+	return s.currentJob
+}
+
+func (s *Server) getJob(id string) *Job {
+	s.RLock()
+	defer s.RUnlock()
+	return s.jobs[id]
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	extraNonce1, err := s.config.ExtraNonces.Allocate()
+	if err != nil {
+		s.log.Println("ERR", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer s.config.ExtraNonces.Release(extraNonce1)
+
+	sess := newSession(s, conn, extraNonce1)
+
+	s.Lock()
+	select {
+	case <-s.done: // accepted before Close, but Close has already disconnected the sessions
+		s.Unlock()
+		sess.close()
+		return
+	default:
+	}
+	s.sessions[sess] = struct{}{}
+	s.Unlock()
+
+	if err := sess.serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.log.Println("ERR", conn.RemoteAddr(), err)
+	}
+
+	s.Lock()
+	delete(s.sessions, sess)
+	s.Unlock()
+}
@@ -0,0 +1,144 @@
+package stratum
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"github.com/inc4/jax/mining/extranonce"
+	"github.com/inc4/jax/mining/job"
+	"github.com/inc4/jax/mining/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetJobSlowMiner(t *testing.T) {
+	s, err := NewServer(testMiner(t), Config{WriteTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, minerConn := net.Pipe()
+	defer minerConn.Close()
+	served := make(chan struct{})
+	go func() {
+		s.handleConn(conn)
+		close(served)
+	}()
+
+	miner := bufio.NewReader(minerConn)
+	for _, req := range []string{
+		`{"id":1,"method":"mining.subscribe","params":[]}`,
+		`{"id":2,"method":"mining.authorize","params":["worker","x"]}`,
+	} {
+		if _, err := minerConn.Write([]byte(req + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := miner.ReadBytes('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := miner.ReadBytes('\n'); err != nil { // mining.set_difficulty
+		t.Fatal(err)
+	}
+
+	// the miner doesn't read jobs
+	start := time.Now()
+	for i := 0; i < 2*jobQueueSize; i++ {
+		s.SetJob(&job.BitcoinJob{Coinbase: &job.CoinBaseTx{}})
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "SetJob waits for the miner")
+
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("slow miner isn't disconnected")
+	}
+	s.RLock()
+	assert.Empty(t, s.sessions)
+	s.RUnlock()
+}
+
+func testMiner(t *testing.T) *mining.Miner {
+	j, err := job.NewJob("mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", "mzDGR33maDBujpqjkvxVzY2ssYDcQG51p3", &network.TestNet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mining.Miner{Job: j}
+}
+
+func TestNewServerExtraNonceSize(t *testing.T) {
+	m := testMiner(t)
+	m.Job.Config.ExtraNonce1Size = 2
+	s, err := NewServer(m, Config{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, s.config.ExtraNonces.Size())
+	}
+
+	allocator, _ := extranonce.NewAllocator(4, 0)
+	_, err = NewServer(m, Config{ExtraNonces: allocator})
+	assert.Error(t, err)
+
+	m.Job.Config.ExtraNonce1Size = 9
+	_, err = NewServer(m, Config{})
+	assert.Error(t, err)
+}
+
+func TestServerClose(t *testing.T) {
+	s, err := NewServer(testMiner(t), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	minerConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer minerConn.Close()
+	miner := bufio.NewReader(minerConn)
+	if _, err := minerConn.Write([]byte(`{"id":1,"method":"mining.subscribe","params":[]}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := miner.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve doesn't return")
+	}
+	_ = minerConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = miner.ReadBytes('\n')
+	assert.Error(t, err) // disconnected
+	assert.Eventually(t, func() bool {
+		s.RLock()
+		defer s.RUnlock()
+		return len(s.sessions) == 0
+	}, time.Second, time.Millisecond)
+
+	// connections accepted before Close are rejected
+	conn, pipe := net.Pipe()
+	defer pipe.Close()
+	s.handleConn(conn)
+	s.RLock()
+	assert.Empty(t, s.sessions)
+	s.RUnlock()
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.Serve(l))
+	_, err = net.Dial("tcp", l.Addr().String())
+	assert.Error(t, err) // the listener is closed
+}
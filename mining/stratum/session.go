@@ -0,0 +1,319 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"github.com/inc4/jax/mining/job"
+	"github.com/inc4/jax/mining/vardiff"
+)
+
+const (
+	maxMessageSize = 16 * 1024
+	jobQueueSize   = 4 // older jobs are dropped if the miner doesn't keep up
+)
+
+type session struct {
+	server *Server
+	conn   net.Conn
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+
+	extraNonce1 []byte
+
+	jobs      chan *Job // sent by the session writer, so a slow miner doesn't block the others
+	done      chan struct{}
+	closeOnce sync.Once
+
+	vardiff *vardiff.VarDiff // nil if the difficulty is fixed
+
+	sync.Mutex
+	subscribed bool
+	workers    map[string]struct{}
+	difficulty float64
+	// prevDifficulty is still accepted until the next job, miners may have shares for it in flight
+	prevDifficulty float64
+}
+
+func newSession(server *Server, conn net.Conn, extraNonce1 []byte) *session {
+	s := &session{
+		server:      server,
+		conn:        conn,
+		encoder:     json.NewEncoder(conn),
+		extraNonce1: extraNonce1,
+		jobs:        make(chan *Job, jobQueueSize),
+		done:        make(chan struct{}),
+		workers:     make(map[string]struct{}),
+		difficulty:  server.config.Difficulty,
+	}
+	if server.config.VarDiff != nil {
+		s.vardiff, _ = vardiff.New(*server.config.VarDiff, server.config.Difficulty, time.Now()) // validated by NewServer
+		s.difficulty = s.vardiff.Difficulty()
+	}
+	return s
+}
+
+func (s *session) serve() error {
+	defer s.close()
+	go s.writeJobs()
+
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Buffer(make([]byte, 0, maxMessageSize), maxMessageSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		req := &Request{}
+		if err := json.Unmarshal(line, req); err != nil {
+			return fmt.Errorf("can't decode request: %w", err)
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
+
+// queueJob schedules the job to be sent, the oldest queued job is dropped if the queue is full.
+func (s *session) queueJob(j *Job) {
+	for {
+		select {
+		case s.jobs <- j:
+			return
+		default:
+		}
+		select {
+		case <-s.jobs:
+		default:
+		}
+	}
+}
+
+func (s *session) writeJobs() {
+	for {
+		select {
+		case j := <-s.jobs:
+			s.sendJob(j)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *session) handle(req *Request) error {
+	var (
+		result interface{}
+		err    *Error
+	)
+	switch req.Method {
+	case "mining.subscribe":
+		result, err = s.handleSubscribe()
+	case "mining.authorize":
+		result, err = s.handleAuthorize(req.Params)
+	case "mining.submit":
+		result, err = s.handleSubmit(req.Params)
+	case "mining.extranonce.subscribe":
+		result = false
+	default:
+		err = NewError(ErrCodeOther, "unknown method "+req.Method)
+	}
+
+	if writeErr := s.send(&Response{ID: req.ID, Result: result, Error: err}); writeErr != nil {
+		return writeErr
+	}
+
+	if req.Method == "mining.authorize" && err == nil && result == true {
+		if writeErr := s.sendDifficulty(); writeErr != nil {
+			return writeErr
+		}
+		if j := s.server.CurrentJob(); j != nil {
+			s.queueJob(j) // in order with the jobs set meanwhile
+		}
+	}
+	return nil
+}
+
+func (s *session) handleSubscribe() (interface{}, *Error) {
+	s.Lock()
+	s.subscribed = true
+	s.Unlock()
+
+	subscriptionID := hex.EncodeToString(s.extraNonce1)
+	return []interface{}{
+		[][]string{
+			{"mining.set_difficulty", subscriptionID},
+			{"mining.notify", subscriptionID},
+		},
+		hex.EncodeToString(s.extraNonce1),
+		s.server.extraNonce2Size,
+	}, nil
+}
+
+func (s *session) handleAuthorize(params []json.RawMessage) (interface{}, *Error) {
+	var worker, password string
+	if err := parseParams(params, &worker); err != nil {
+		return nil, err
+	}
+	if len(params) > 1 {
+		_ = json.Unmarshal(params[1], &password)
+	}
+
+	if authorize := s.server.config.Authorize; authorize != nil && !authorize(worker, password) {
+		return false, nil
+	}
+
+	s.Lock()
+	s.workers[worker] = struct{}{}
+	s.Unlock()
+	return true, nil
+}
+
+func (s *session) handleSubmit(params []json.RawMessage) (interface{}, *Error) {
+	var worker, jobID, extraNonce2Hex, ntimeHex, nonceHex string
+	if err := parseParams(params, &worker, &jobID, &extraNonce2Hex, &ntimeHex, &nonceHex); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	_, authorized := s.workers[worker]
+	subscribed := s.subscribed
+	difficulty := s.difficulty
+	if s.prevDifficulty != 0 && s.prevDifficulty < difficulty {
+		difficulty = s.prevDifficulty
+	}
+	s.Unlock()
+
+	if !subscribed {
+		return nil, errNotSubscribed
+	}
+	if !authorized {
+		return nil, errUnauthorized
+	}
+
+	j := s.server.getJob(jobID)
+	if j == nil {
+		return nil, errJobNotFound
+	}
+
+	extraNonce2, err := hex.DecodeString(extraNonce2Hex)
+	if err != nil || len(extraNonce2) != s.server.extraNonce2Size {
+		return nil, NewError(ErrCodeOther, "invalid extranonce2")
+	}
+	ntime, err := parseUint32Hex(ntimeHex)
+	if err != nil {
+		return nil, NewError(ErrCodeOther, "invalid ntime")
+	}
+	nonce, err := parseUint32Hex(nonceHex)
+	if err != nil {
+		return nil, NewError(ErrCodeOther, "invalid nonce")
+	}
+
+	if !j.registerShare(s.extraNonce1, extraNonce2, ntime, nonce) {
+		return nil, errDuplicateShare
+	}
+
+	coinbase := j.BuildCoinbase(s.extraNonce1, extraNonce2)
+	header := j.Header(coinbase, ntime, nonce)
+
+	verdict := s.server.miner.Share(j.Coinbase.JobID, serializeHeader(header), coinbase, j.TxHashes, mining.DifficultyToTarget(difficulty))
+	for _, r := range verdict.Results {
+		if r.Err != nil {
+			s.server.log.Println("ERR", r.Err)
+		}
+	}
+	if !verdict.Accepted {
+		switch {
+		case errors.Is(verdict.Reason, mining.ErrLowDifficulty):
+			return nil, errLowDifficulty
+		case errors.Is(verdict.Reason, job.ErrStaleJob), errors.Is(verdict.Reason, job.ErrUnknownJob):
+			return nil, errStaleJob
+		default:
+			return nil, NewError(ErrCodeOther, verdict.Reason.Error())
+		}
+	}
+
+	if onShare := s.server.config.OnShare; onShare != nil {
+		onShare(worker, difficulty, verdict)
+	}
+
+	if s.vardiff != nil {
+		if newDifficulty, changed := s.vardiff.Share(time.Now()); changed {
+			s.setDifficulty(newDifficulty)
+		}
+	}
+	return true, nil
+}
+
+// setDifficulty sends the new difficulty, it's applied to the next job sent to the miner.
+func (s *session) setDifficulty(difficulty float64) {
+	s.Lock()
+	s.prevDifficulty = s.difficulty
+	s.difficulty = difficulty
+	s.Unlock()
+
+	if err := s.sendDifficulty(); err != nil {
+		s.server.log.Println("ERR", s.conn.RemoteAddr(), err)
+		s.close()
+	}
+}
+
+func (s *session) sendDifficulty() error {
+	s.Lock()
+	difficulty := s.difficulty
+	s.Unlock()
+	return s.send(&Notification{Method: "mining.set_difficulty", Params: []interface{}{difficulty}})
+}
+
+func (s *session) sendJob(j *Job) {
+	s.Lock()
+	ready := s.subscribed && len(s.workers) > 0
+	s.Unlock()
+	if !ready {
+		return
+	}
+	s.Lock()
+	s.prevDifficulty = 0
+	s.Unlock()
+	if s.vardiff != nil {
+		if difficulty, changed := s.vardiff.Retarget(time.Now()); changed { // miner may not find shares at all
+			s.setDifficulty(difficulty)
+		}
+	}
+
+	if err := s.send(&Notification{Method: "mining.notify", Params: j.NotifyParams()}); err != nil {
+		s.server.log.Println("ERR", s.conn.RemoteAddr(), err)
+		s.close()
+	}
+}
+
+// send closes the connection if msg isn't written in time, the miner may have got a part of it.
+func (s *session) send(msg interface{}) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.server.config.WriteTimeout)); err != nil {
+		s.close()
+		return err
+	}
+	if err := s.encoder.Encode(msg); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package mining
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/jaxnet/jaxnetd/network/rpcclient"
+)
+
+const submitQueueSize = 64
+
+var (
+	errSubmitTimeout   = errors.New("submit timeout")
+	errSubmitQueueFull = errors.New("submit queue is full")
+)
+
+type SubmitConfig struct {
+	Workers    int
+	Attempts   int
+	Timeout    time.Duration // of one attempt
+	RetryDelay time.Duration
+	QueueWait  time.Duration // how long a share waits for a free queue slot before the block is dropped
+	// OnResult is called from a submit worker with the final result of every block, Err is the last submit error.
+	// Errors are only logged if nil.
+	OnResult func(*MinerResult)
+}
+
+var DefaultSubmitConfig = SubmitConfig{
+	Workers:    4,
+	Attempts:   3,
+	Timeout:    10 * time.Second,
+	RetryDelay: time.Second,
+	QueueWait:  time.Second,
+}
+
+type submitTask struct {
+	result *MinerResult
+	send   func(jaxClient *rpcclient.Client) error
+}
+
+// submitter sends the found blocks concurrently, so a slow node doesn't stall share validation.
+// Every worker keeps its own jaxnetd client, the client can't be shared because of ForShard.
+type submitter struct {
+	miner  *Miner
+	config SubmitConfig
+	tasks  chan *submitTask
+	wg     sync.WaitGroup
+	log    *log.Logger
+}
+
+// StartSubmitter makes block submission asynchronous, results are delivered to config.OnResult.
+// Results returned by Share and Solution are Pending then, so consumers of the submit outcome
+// (accounting.Ledger.AddBlock, storage.SaveResults, tracker.Tracker.Track) must be called from OnResult.
+// Call it again to change the config.
+func (m *Miner) StartSubmitter(config SubmitConfig) {
+	if config.Workers <= 0 {
+		config.Workers = DefaultSubmitConfig.Workers
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultSubmitConfig.Timeout
+	}
+	if config.QueueWait <= 0 {
+		config.QueueWait = DefaultSubmitConfig.QueueWait
+	}
+
+	s := &submitter{
+		miner:  m,
+		config: config,
+		tasks:  make(chan *submitTask, submitQueueSize),
+		log:    log.Default(),
+	}
+	for i := 0; i < config.Workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+
+	m.submitMu.Lock()
+	prev := m.submitter
+	m.submitter = s
+	m.submitMu.Unlock()
+
+	if prev != nil {
+		prev.stop()
+	}
+}
+
+// StopSubmitter waits until queued blocks are submitted. Blocks found after it are submitted synchronously.
+func (m *Miner) StopSubmitter() {
+	m.submitMu.Lock()
+	s := m.submitter
+	m.submitter = nil
+	m.submitMu.Unlock()
+
+	if s != nil {
+		s.stop()
+	}
+}
+
+// submit queues the block. Without the submitter the block is sent synchronously and r.Err is set.
+// If the queue stays full for config.QueueWait, the block is dropped and r.Err is set.
+func (m *Miner) submit(r *MinerResult, send func(jaxClient *rpcclient.Client) error) {
+	m.submitMu.RLock()
+	defer m.submitMu.RUnlock()
+
+	if m.submitter == nil {
+		r.Err = wrapSubmitError(r, m.submitSync(send))
+		return
+	}
+
+	result := *r // the caller owns r
+	task := &submitTask{result: &result, send: send}
+	select {
+	case m.submitter.tasks <- task:
+		r.Pending = true
+	case <-time.After(m.submitter.config.QueueWait):
+		r.Err = wrapSubmitError(r, errSubmitQueueFull)
+	}
+}
+
+func (m *Miner) submitSync(send func(jaxClient *rpcclient.Client) error) error {
+	client, err := rpcclient.New(m.rpcConf, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Shutdown()
+	return send(client)
+}
+
+func (s *submitter) stop() {
+	close(s.tasks)
+	s.wg.Wait()
+}
+
+func (s *submitter) work() {
+	defer s.wg.Done()
+
+	var client *rpcclient.Client
+	defer func() {
+		if client != nil {
+			client.Shutdown()
+		}
+	}()
+
+	for task := range s.tasks {
+		var err error
+		for attempt := 1; attempt <= s.config.Attempts; attempt++ {
+			if attempt > 1 {
+				time.Sleep(s.config.RetryDelay)
+			}
+			if client == nil {
+				if client, err = rpcclient.New(s.miner.rpcConf, nil); err != nil {
+					client = nil
+					continue
+				}
+			}
+
+			err = s.send(task, client)
+			if err != nil && attempt > 1 && isDuplicateBlock(task.result, err) {
+				err = nil // previous attempt reached the node
+			}
+			if err == nil {
+				break
+			}
+			if errors.Is(err, errSubmitTimeout) {
+				client = nil // the client may be stuck, send shuts it down
+			}
+		}
+
+		task.result.Err = wrapSubmitError(task.result, err)
+		if s.config.OnResult != nil {
+			s.config.OnResult(task.result)
+		} else if task.result.Err != nil {
+			s.log.Println("ERR", task.result.Err)
+		}
+	}
+}
+
+func (s *submitter) send(task *submitTask, client *rpcclient.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- task.send(client)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.config.Timeout):
+		// stops the client handlers, the request in flight ends with its HTTP connection
+		client.Shutdown()
+		return errSubmitTimeout
+	}
+}
+
+// isDuplicateBlock reports whether the node already has the block. Neither node uses an RPC error code for it:
+// jaxnetd returns "rejected: already have block <hash>" result (rule error ErrDuplicateBlock)
+// and bitcoind returns BIP22 "duplicate" result, rpcclient turns the result into the error.
+func isDuplicateBlock(r *MinerResult, err error) bool {
+	if r.IsBtc {
+		return err.Error() == "duplicate"
+	}
+	return strings.HasPrefix(err.Error(), "rejected: already have block ")
+}
+
+func wrapSubmitError(r *MinerResult, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case r.IsBtc:
+		return fmt.Errorf("can't submit bitcoin block: %w", err)
+	default:
+		return fmt.Errorf("can't submit block (shardId=%v): %w", r.ShardId, err)
+	}
+}
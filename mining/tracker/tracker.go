@@ -0,0 +1,191 @@
+// Package tracker follows the submitted jax blocks until they are confirmed or orphaned.
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/inc4/jax/mining"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+type State int
+
+const (
+	// Pending block has less than Config.Confirmations confirmations.
+	Pending State = iota
+	// Immature block is confirmed, but its coinbase can't be spent yet.
+	Immature
+	// Confirmed block is final, its reward is spendable. The block is not tracked anymore.
+	Confirmed
+	// Orphaned block is replaced in the main chain by the block with Config.Confirmations confirmations.
+	// The block is not tracked anymore.
+	Orphaned
+)
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Immature:
+		return "immature"
+	case Confirmed:
+		return "confirmed"
+	case Orphaned:
+		return "orphaned"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Chain is the jaxnetd view of the shards, mining.Miner implements it.
+type Chain interface {
+	// BlockAt returns the hash of the main chain block at the height and the best height of the shard (0 is beacon).
+	// The hash is zero if the height is above the best one.
+	BlockAt(shardID uint32, height int64) (hash chainhash.Hash, bestHeight int64, err error)
+}
+
+var _ Chain = (*mining.Miner)(nil)
+
+type Config struct {
+	Confirmations    int64
+	CoinbaseMaturity int64 // chaincfg.Params.CoinbaseMaturity
+	PollInterval     time.Duration
+	// OnChange is called from the polling goroutine when the block state changes.
+	OnChange func(Event)
+}
+
+type Event struct {
+	Result        *mining.MinerResult
+	State         State
+	Confirmations int64
+}
+
+type entry struct {
+	result *mining.MinerResult
+	state  State
+}
+
+// Tracker polls the chain for the tracked blocks. It's safe for concurrent use.
+type Tracker struct {
+	chain  Chain
+	config Config
+	log    *log.Logger
+
+	mu      sync.Mutex
+	entries map[chainhash.Hash]*entry
+}
+
+func New(chain Chain, config Config) *Tracker {
+	if config.Confirmations < 1 {
+		config.Confirmations = 1
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Minute
+	}
+	return &Tracker{
+		chain:   chain,
+		config:  config,
+		log:     log.Default(),
+		entries: make(map[chainhash.Hash]*entry),
+	}
+}
+
+// Track starts tracking the submitted block. Bitcoin, pending and failed to submit blocks are ignored.
+func (t *Tracker) Track(r *mining.MinerResult) {
+	if r.IsBtc || r.Err != nil || r.Pending {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.entries[r.BlockHash]; !ok {
+		t.entries[r.BlockHash] = &entry{result: r, state: Pending}
+	}
+}
+
+// Tracked returns the number of the blocks being tracked.
+func (t *Tracker) Tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+// Run polls the chain until the context is canceled.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.Poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks all tracked blocks once.
+func (t *Tracker) Poll() {
+	t.mu.Lock()
+	entries := make([]*entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	t.mu.Unlock()
+
+	for _, e := range entries {
+		state, confirmations, err := t.check(e.result)
+		if err != nil {
+			t.log.Println("ERR", err)
+			continue
+		}
+		if state == e.state {
+			continue
+		}
+
+		t.mu.Lock()
+		e.state = state
+		if state == Confirmed || state == Orphaned {
+			delete(t.entries, e.result.BlockHash)
+		}
+		t.mu.Unlock()
+
+		if t.config.OnChange != nil {
+			t.config.OnChange(Event{Result: e.result, State: state, Confirmations: confirmations})
+		}
+	}
+}
+
+func (t *Tracker) check(r *mining.MinerResult) (State, int64, error) {
+	hash, best, err := t.chain.BlockAt(r.ShardId, r.BlockHeight)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't get block %v of shard %v: %w", r.BlockHeight, r.ShardId, err)
+	}
+
+	confirmations := best - r.BlockHeight + 1
+	if confirmations < 1 { // chain is behind after reorg or the node is not synced
+		return Pending, 0, nil
+	}
+
+	if hash != r.BlockHash {
+		if confirmations >= t.config.Confirmations {
+			return Orphaned, 0, nil
+		}
+		return Pending, 0, nil
+	}
+
+	switch {
+	case confirmations < t.config.Confirmations:
+		return Pending, confirmations, nil
+	case confirmations < t.config.CoinbaseMaturity:
+		return Immature, confirmations, nil
+	default:
+		return Confirmed, confirmations, nil
+	}
+}
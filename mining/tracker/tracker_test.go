@@ -0,0 +1,55 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/inc4/jax/mining"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/jaxnet/jaxnetd/types/chainhash"
+)
+
+type testChain struct {
+	best   int64
+	blocks map[int64]chainhash.Hash
+}
+
+func (c *testChain) BlockAt(shardID uint32, height int64) (chainhash.Hash, int64, error) {
+	return c.blocks[height], c.best, nil
+}
+
+func TestTracker(t *testing.T) {
+	chain := &testChain{best: 10, blocks: map[int64]chainhash.Hash{10: {1}, 11: {9}}}
+	var events []Event
+	tr := New(chain, Config{Confirmations: 2, CoinbaseMaturity: 4, OnChange: func(e Event) {
+		events = append(events, e)
+	}})
+
+	won := &mining.MinerResult{ShardId: 1, BlockHeight: 10, BlockHash: chainhash.Hash{1}}
+	lost := &mining.MinerResult{ShardId: 1, BlockHeight: 11, BlockHash: chainhash.Hash{2}}
+	tr.Track(won)
+	tr.Track(lost)
+	tr.Track(&mining.MinerResult{IsBtc: true, BlockHash: chainhash.Hash{3}})
+	assert.Equal(t, 2, tr.Tracked())
+
+	tr.Poll() // 1 confirmation, lost block is not in the chain yet
+	assert.Empty(t, events)
+
+	chain.best = 11
+	tr.Poll() // lost block is replaced, but the replacement has 1 confirmation only
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, won, events[0].Result)
+		assert.Equal(t, Immature, events[0].State)
+		assert.Equal(t, int64(2), events[0].Confirmations)
+	}
+
+	chain.best = 13
+	events = nil
+	tr.Poll()
+	assert.Len(t, events, 2)
+	states := map[*mining.MinerResult]State{}
+	for _, e := range events {
+		states[e.Result] = e.State
+	}
+	assert.Equal(t, map[*mining.MinerResult]State{won: Confirmed, lost: Orphaned}, states)
+	assert.Equal(t, 0, tr.Tracked())
+}
@@ -0,0 +1,136 @@
+// Package vardiff adjusts the share difficulty of a miner to its hashrate,
+// so every miner submits about the same number of shares regardless of its power.
+package vardiff
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxStep limits how much the difficulty changes on one retarget.
+const maxStep = 4
+
+type Config struct {
+	MinDifficulty float64
+	MaxDifficulty float64 // unlimited if 0
+	// TargetSharesPerMinute is the desired share rate of the miner.
+	TargetSharesPerMinute float64
+	// RetargetWindow is how long shares are counted before the difficulty is reconsidered.
+	RetargetWindow time.Duration
+	// Variance is the allowed relative deviation of the share rate, e.g. 0.3 keeps the difficulty
+	// if the rate is within 30% of the target one. Avoids resending the difficulty on the noise.
+	Variance float64
+}
+
+var DefaultConfig = Config{
+	MinDifficulty:         1,
+	TargetSharesPerMinute: 20,
+	RetargetWindow:        90 * time.Second,
+	Variance:              0.3,
+}
+
+func (c *Config) validate() error {
+	if c.MinDifficulty <= 0 {
+		return fmt.Errorf("min difficulty must be positive")
+	}
+	if c.MaxDifficulty != 0 && c.MaxDifficulty < c.MinDifficulty {
+		return fmt.Errorf("max difficulty %v is less than min difficulty %v", c.MaxDifficulty, c.MinDifficulty)
+	}
+	if c.TargetSharesPerMinute <= 0 {
+		return fmt.Errorf("target shares per minute must be positive")
+	}
+	if c.RetargetWindow <= 0 {
+		return fmt.Errorf("retarget window must be positive")
+	}
+	if c.Variance < 0 {
+		return fmt.Errorf("variance can't be negative")
+	}
+	return nil
+}
+
+// VarDiff tracks the share rate of one miner. It's safe for concurrent use.
+type VarDiff struct {
+	config Config
+
+	mu          sync.Mutex
+	difficulty  float64
+	windowStart time.Time
+	shares      int
+}
+
+// New creates the vardiff for a miner connected at `now` starting from `difficulty`.
+func New(config Config, difficulty float64, now time.Time) (*VarDiff, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	v := &VarDiff{config: config, windowStart: now}
+	v.difficulty = v.clamp(difficulty)
+	return v, nil
+}
+
+// Difficulty returns the current share difficulty.
+func (v *VarDiff) Difficulty() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.difficulty
+}
+
+// Share records the accepted share and retargets if the window is over.
+// It returns the new difficulty and true if the difficulty has changed.
+func (v *VarDiff) Share(now time.Time) (float64, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.shares++
+	return v.retarget(now)
+}
+
+// Retarget reconsiders the difficulty without a share, it must be called periodically (e.g. on every new job)
+// to lower the difficulty of the miners that can't find any share.
+func (v *VarDiff) Retarget(now time.Time) (float64, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.retarget(now)
+}
+
+func (v *VarDiff) retarget(now time.Time) (float64, bool) {
+	elapsed := now.Sub(v.windowStart)
+	if elapsed < v.config.RetargetWindow {
+		return v.difficulty, false
+	}
+
+	rate := float64(v.shares) / elapsed.Minutes()
+	ratio := rate / v.config.TargetSharesPerMinute
+
+	v.windowStart = now
+	v.shares = 0
+
+	if ratio >= 1-v.config.Variance && ratio <= 1+v.config.Variance {
+		return v.difficulty, false
+	}
+	if ratio > maxStep {
+		ratio = maxStep
+	}
+	if ratio < 1.0/maxStep {
+		ratio = 1.0 / maxStep
+	}
+
+	difficulty := v.clamp(v.difficulty * ratio)
+	if difficulty == v.difficulty {
+		return v.difficulty, false
+	}
+	v.difficulty = difficulty
+	return difficulty, true
+}
+
+func (v *VarDiff) clamp(difficulty float64) float64 {
+	if difficulty < v.config.MinDifficulty {
+		return v.config.MinDifficulty
+	}
+	if v.config.MaxDifficulty != 0 && difficulty > v.config.MaxDifficulty {
+		return v.config.MaxDifficulty
+	}
+	return difficulty
+}
@@ -0,0 +1,68 @@
+package vardiff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVarDiff(t *testing.T) {
+	config := Config{
+		MinDifficulty:         1,
+		MaxDifficulty:         1000,
+		TargetSharesPerMinute: 10,
+		RetargetWindow:        time.Minute,
+		Variance:              0.2,
+	}
+	now := time.Unix(0, 0)
+	v, err := New(config, 10, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 shares per minute, twice more than needed
+	for i := 1; i < 20; i++ {
+		_, changed := v.Share(now.Add(time.Duration(i) * 3 * time.Second))
+		assert.False(t, changed)
+	}
+	difficulty, changed := v.Share(now.Add(time.Minute))
+	assert.True(t, changed)
+	assert.Equal(t, 20.0, difficulty)
+
+	// rate within the variance
+	now = now.Add(time.Minute)
+	for i := 1; i <= 11; i++ {
+		difficulty, changed = v.Share(now.Add(time.Duration(i) * time.Minute / 11))
+	}
+	assert.False(t, changed)
+	assert.Equal(t, 20.0, v.Difficulty())
+
+	// no shares, the step is limited
+	now = now.Add(time.Minute)
+	difficulty, changed = v.Retarget(now.Add(time.Minute))
+	assert.True(t, changed)
+	assert.Equal(t, 5.0, difficulty)
+
+	// clamped by min difficulty
+	now = now.Add(time.Minute)
+	difficulty, _ = v.Retarget(now.Add(time.Minute))
+	assert.Equal(t, 1.25, difficulty)
+	difficulty, _ = v.Retarget(now.Add(2 * time.Minute))
+	assert.Equal(t, 1.0, difficulty)
+	_, changed = v.Retarget(now.Add(3 * time.Minute))
+	assert.False(t, changed)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := New(DefaultConfig, 1, time.Now())
+	assert.NoError(t, err)
+
+	bad := DefaultConfig
+	bad.MaxDifficulty = 0.5
+	_, err = New(bad, 1, time.Now())
+	assert.Error(t, err)
+
+	v, _ := New(Config{MinDifficulty: 2, MaxDifficulty: 4, TargetSharesPerMinute: 1, RetargetWindow: time.Second}, 100, time.Now())
+	assert.Equal(t, 4.0, v.Difficulty())
+}